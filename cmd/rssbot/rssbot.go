@@ -7,15 +7,22 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata" // for /timezone on hosts without a zoneinfo database
+
 	"github.com/shayne/go-rss-telegram-bot/pkg/rssbot"
 )
 
 func main() {
 	var (
-		dbPath       = flag.String("db", "db.json", "Path to the database JSON file")
-		checkInterval = flag.Duration("check-interval", time.Hour, "Interval between RSS feed checks")
-		allowedChats = flag.String("allowed-chats", "", "Comma-separated list of allowed Telegram chat IDs")
+		dbPath        = flag.String("db", "db.json", "Database location: a JSON file path, or sqlite:<path> for an SQLite database")
+		checkInterval = flag.Duration("check-interval", time.Hour, "Initial interval between checks of each feed, adapted to how often it publishes")
+		allowedChats  = flag.String("allowed-chats", "", "Comma-separated list of allowed Telegram chat IDs")
+		workers       = flag.Int("workers", 4, "Number of feeds to check concurrently")
+		maxFeedErrors = flag.Int("max-feed-errors", 10, "Consecutive failed checks before a feed is marked broken (0 disables)")
+		failureNotice = flag.Duration("failure-notice-after", 72*time.Hour, "How long a feed must fail before subscribers are warned, repeated per period (0 disables)")
+		excerptLength = flag.Int("excerpt-length", 0, "Maximum length of the item excerpt included in notifications (0 disables)")
 	)
 	flag.Parse()
 
@@ -34,19 +41,26 @@ func main() {
 
 	log.Printf("Starting RSS bot with database at %s", *dbPath)
 	log.Printf("Check interval: %v", *checkInterval)
+	log.Printf("Feed check workers: %d", *workers)
 	if len(allowList) > 0 {
 		log.Printf("Allowed chat IDs: %v", allowList)
 	} else {
 		log.Printf("No chat restrictions (allow list is empty)")
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	// Stopping on SIGTERM too lets Run save the database when a container
+	// is stopped.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	cfg := &rssbot.Config{
-		DBPath:        *dbPath,
-		CheckInterval: *checkInterval,
-		AllowedChatIDs: allowList,
+		DBPath:             *dbPath,
+		CheckInterval:      *checkInterval,
+		AllowedChatIDs:     allowList,
+		Workers:            *workers,
+		MaxFeedErrors:      *maxFeedErrors,
+		FailureNoticeAfter: *failureNotice,
+		ExcerptLength:      *excerptLength,
 	}
 
 	rssBot, err := rssbot.New(apiKey, cfg)
@@ -0,0 +1,75 @@
+package rssbot
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// newXMLDecoder returns a decoder for data that converts documents declaring
+// a non-UTF-8 encoding, e.g. <?xml version="1.0" encoding="windows-1251"?>,
+// to UTF-8.
+func newXMLDecoder(data []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = charsetReader
+	return d
+}
+
+// charsetReader converts input in the named encoding to UTF-8. Labels are
+// looked up as in the WHATWG Encoding Standard, so "ISO-8859-1" is read as
+// windows-1252 like browsers do.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	return charset.NewReaderLabel(label, input)
+}
+
+// xmlDeclEncoding matches the encoding attribute of an XML declaration.
+var xmlDeclEncoding = regexp.MustCompile(`^(\x{FEFF}?\s*<\?xml[^>]*?\sencoding\s*=\s*)("[^"]*"|'[^']*')`)
+
+// decodeCharset applies the charset parameter of the HTTP Content-Type, which
+// takes precedence over the document's own declaration. The body is
+// converted to UTF-8 and its XML declaration rewritten to match, so
+// newXMLDecoder doesn't convert it a second time. Without a usable HTTP
+// charset, data is returned unchanged and the XML declaration applies.
+//
+// Servers often claim UTF-8 by default, so a UTF-8 charset is only trusted
+// for bodies that are valid UTF-8.
+func decodeCharset(data []byte, contentType string) []byte {
+	label := contentTypeCharset(contentType)
+	if label == "" {
+		return data
+	}
+	enc, name := charset.Lookup(label)
+	if enc == nil {
+		return data
+	}
+
+	if name != "utf-8" {
+		decoded, err := enc.NewDecoder().Bytes(data)
+		if err != nil {
+			return data
+		}
+		data = decoded
+	} else if !utf8.Valid(data) {
+		return data
+	}
+	return xmlDeclEncoding.ReplaceAll(data, []byte(`${1}"UTF-8"`))
+}
+
+// contentTypeCharset returns the charset parameter of an HTTP Content-Type
+// header, or "".
+func contentTypeCharset(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params["charset"])
+}
@@ -0,0 +1,96 @@
+package rssbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFeedCharsets(t *testing.T) {
+	rss := func(decl, title string) []byte {
+		return []byte(`<?xml version="1.0"` + decl + `?><rss version="2.0"><channel><title>` + title + `</title></channel></rss>`)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        string
+	}{
+		{
+			name: "Windows-1251 declaration",
+			data: rss(` encoding="windows-1251"`, "\xcf\xf0\xe8\xe2\xe5\xf2"),
+			want: "Привет",
+		},
+		{
+			name: "ISO-8859-1 declaration",
+			data: rss(` encoding='ISO-8859-1'`, "Caf\xe9"),
+			want: "Café",
+		},
+		{
+			name: "Shift_JIS declaration",
+			data: rss(` encoding="Shift_JIS"`, "\x93\xfa\x96\x7b"),
+			want: "日本",
+		},
+		{
+			name:        "HTTP charset without declaration",
+			data:        rss("", "\xcf\xf0\xe8\xe2\xe5\xf2"),
+			contentType: "application/rss+xml; charset=windows-1251",
+			want:        "Привет",
+		},
+		{
+			name:        "HTTP charset overrides declaration",
+			data:        rss(` encoding="utf-8"`, "Caf\xe9"),
+			contentType: "text/xml; charset=iso-8859-1",
+			want:        "Café",
+		},
+		{
+			name:        "HTTP UTF-8 with UTF-8 body",
+			data:        rss(` encoding="windows-1251"`, "Привет"),
+			contentType: "text/xml; charset=UTF-8",
+			want:        "Привет",
+		},
+		{
+			name:        "Wrong HTTP UTF-8 falls back to declaration",
+			data:        rss(` encoding="windows-1251"`, "\xcf\xf0\xe8\xe2\xe5\xf2"),
+			contentType: "text/xml; charset=utf-8",
+			want:        "Привет",
+		},
+		{
+			name:        "Unknown HTTP charset",
+			data:        rss(` encoding="windows-1251"`, "\xcf\xf0\xe8\xe2\xe5\xf2"),
+			contentType: "text/xml; charset=x-bogus",
+			want:        "Привет",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed(tt.data, tt.contentType)
+			if err != nil {
+				t.Fatalf("parseFeed() error = %v", err)
+			}
+			if feed.Info.Title != tt.want {
+				t.Errorf("Title = %q, want %q", feed.Info.Title, tt.want)
+			}
+		})
+	}
+}
+
+func TestTryFindFeedAtURLCharset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=windows-1251")
+		w.Write([]byte("<feed xmlns=\"http://www.w3.org/2005/Atom\"><title>\xcd\xee\xe2\xee\xf1\xf2\xe8</title></feed>"))
+	}))
+	defer server.Close()
+
+	bot := &Bot{}
+	_, feedInfo, err := bot.tryFindFeedAtURL(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedInfo.Title != "Новости" {
+		t.Errorf("Title = %q, want %q", feedInfo.Title, "Новости")
+	}
+}
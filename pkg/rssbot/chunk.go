@@ -0,0 +1,249 @@
+package rssbot
+
+import (
+	"html"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Telegram's limits on the length of a message and of a media caption, in
+// UTF-16 code units of text after entities are parsed.
+const (
+	maxMessageLength = 4096
+	maxCaptionLength = 1024
+)
+
+// messageAtom is an indivisible piece of a message: a tag, an HTML entity
+// or a single character.
+type messageAtom struct {
+	raw string
+	// width is the atom's visible length in UTF-16 code units; tags
+	// have none.
+	width int
+	// tag is the element name of a tag, and end whether it closes it.
+	tag string
+	end bool
+}
+
+// splitMessage splits text into messages of at most limit visible UTF-16
+// code units each. It prefers to split between paragraphs, then between
+// lines, then between words, and only cuts a word if it has to. In HTML
+// mode, tags and entities are never split, and tags open at a split are
+// closed at the end of one message and reopened at the start of the next.
+func splitMessage(text string, limit int, isHTML bool) []string {
+	atoms := parseMessageAtoms(text, isHTML)
+	if atomsWidth(atoms) <= limit {
+		return []string{text}
+	}
+
+	var chunks []string
+	var open []messageAtom
+	for start := 0; start < len(atoms); {
+		start = skipSpace(atoms, start)
+		end := splitPoint(atoms, start, limit)
+
+		closing := openTags(open, atoms[start:end])
+		chunk := renderAtoms(open, trimTrailingSpace(atoms[start:end]), closing)
+		if strings.TrimSpace(chunk) != "" {
+			chunks = append(chunks, chunk)
+		}
+		open, start = closing, end
+	}
+	return chunks
+}
+
+// truncateMessage cuts text to at most limit visible UTF-16 code units,
+// ending it with "…" if anything was cut.
+func truncateMessage(text string, limit int, isHTML bool) string {
+	atoms := parseMessageAtoms(text, isHTML)
+	if atomsWidth(atoms) <= limit {
+		return text
+	}
+	end := splitPoint(atoms, 0, limit-1)
+	closing := openTags(nil, atoms[:end])
+	return renderAtoms(nil, trimTrailingSpace(atoms[:end]), nil) + "…" + renderAtoms(nil, nil, closing)
+}
+
+// splitPoint returns where the message starting at atoms[start] should end
+// to hold at most limit visible code units.
+func splitPoint(atoms []messageAtom, start, limit int) int {
+	width, end := 0, start
+	for end < len(atoms) && width+atoms[end].width <= limit {
+		width += atoms[end].width
+		end++
+	}
+	if end == len(atoms) {
+		return end
+	}
+
+	// Look for the best break in the second half of the message, so a
+	// message isn't cut short for an early paragraph break. Failing that,
+	// take the last break in the first half rather than cut a word.
+	best, bestRank, fallback := -1, 0, -1
+	width = 0
+	for i := start; i < end; i++ {
+		width += atoms[i].width
+		rank := breakRank(atoms, i)
+		switch {
+		case rank == 0:
+		case width < limit/2:
+			fallback = i + 1
+		case rank >= bestRank:
+			best, bestRank = i+1, rank
+		}
+	}
+	if rank := breakRank(atoms, end); rank > 0 && rank >= bestRank {
+		// The text fits right up to a break.
+		best = end
+	}
+	if best < 0 {
+		best = fallback
+	}
+	if best < 0 {
+		best = end
+	}
+	if best == start {
+		// A single atom wider than the limit; send it on its own.
+		best = start + 1
+	}
+
+	// Keep closing tags with the text they close and opening tags with
+	// the text they open.
+	for best < len(atoms) && atoms[best].tag != "" && atoms[best].end {
+		best++
+	}
+	for best > start+1 && atoms[best-1].tag != "" && !atoms[best-1].end {
+		best--
+	}
+	return best
+}
+
+// breakRank ranks a split at the whitespace atoms[i]: 3 in a blank line, 2
+// at a line break, 1 at a space and 0 elsewhere.
+func breakRank(atoms []messageAtom, i int) int {
+	switch atoms[i].raw {
+	case "\n":
+		if i > 0 && atoms[i-1].raw == "\n" || i+1 < len(atoms) && atoms[i+1].raw == "\n" {
+			return 3
+		}
+		return 2
+	case " ", "\t":
+		return 1
+	}
+	return 0
+}
+
+// openTags returns the tags open after atoms, given those open before
+// them.
+func openTags(open, atoms []messageAtom) []messageAtom {
+	stack := append([]messageAtom(nil), open...)
+	for _, a := range atoms {
+		switch {
+		case a.tag == "":
+		case !a.end:
+			stack = append(stack, a)
+		default:
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].tag == a.tag {
+					stack = stack[:i]
+					break
+				}
+			}
+		}
+	}
+	return stack
+}
+
+// renderAtoms reopens the tags in open, writes atoms and closes the tags in
+// closing.
+func renderAtoms(open, atoms, closing []messageAtom) string {
+	var b strings.Builder
+	for _, a := range open {
+		b.WriteString(a.raw)
+	}
+	for _, a := range atoms {
+		b.WriteString(a.raw)
+	}
+	for i := len(closing) - 1; i >= 0; i-- {
+		b.WriteString("</" + closing[i].tag + ">")
+	}
+	return b.String()
+}
+
+func isSpaceAtom(a messageAtom) bool {
+	return a.raw == " " || a.raw == "\n" || a.raw == "\t"
+}
+
+func skipSpace(atoms []messageAtom, i int) int {
+	for i < len(atoms) && isSpaceAtom(atoms[i]) {
+		i++
+	}
+	return i
+}
+
+// trimTrailingSpace drops whitespace at the end of atoms, also from before
+// trailing closing tags.
+func trimTrailingSpace(atoms []messageAtom) []messageAtom {
+	var tail []messageAtom
+	for len(atoms) > 0 {
+		last := atoms[len(atoms)-1]
+		switch {
+		case isSpaceAtom(last):
+		case last.tag != "" && last.end:
+			tail = append([]messageAtom{last}, tail...)
+		default:
+			return append(atoms[:len(atoms):len(atoms)], tail...)
+		}
+		atoms = atoms[:len(atoms)-1]
+	}
+	return tail
+}
+
+func atomsWidth(atoms []messageAtom) int {
+	width := 0
+	for _, a := range atoms {
+		width += a.width
+	}
+	return width
+}
+
+// parseMessageAtoms splits a message into atoms. Outside HTML mode every
+// character is an atom.
+func parseMessageAtoms(text string, isHTML bool) []messageAtom {
+	atoms := make([]messageAtom, 0, len(text))
+	for len(text) > 0 {
+		var a messageAtom
+		switch {
+		case isHTML && text[0] == '<' && strings.Contains(text, ">"):
+			a.raw = text[:strings.Index(text, ">")+1]
+			name := strings.TrimPrefix(a.raw[1:len(a.raw)-1], "/")
+			a.tag, _, _ = strings.Cut(name, " ")
+			a.end = strings.HasPrefix(a.raw, "</")
+		case isHTML && text[0] == '&' && entityEnd(text) > 0:
+			a.raw = text[:entityEnd(text)]
+			a.width = utf16Len(html.UnescapeString(a.raw))
+		default:
+			r, size := utf8.DecodeRuneInString(text)
+			a.raw = text[:size]
+			a.width = max(utf16.RuneLen(r), 1)
+		}
+		atoms = append(atoms, a)
+		text = text[len(a.raw):]
+	}
+	return atoms
+}
+
+// entityEnd returns the length of the HTML entity text starts with, or 0.
+func entityEnd(text string) int {
+	i := strings.IndexByte(text, ';')
+	if i < 2 || i > 32 {
+		return 0
+	}
+	for _, c := range text[1:i] {
+		if !(c == '#' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return 0
+		}
+	}
+	return i + 1
+}
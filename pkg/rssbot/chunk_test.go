@@ -0,0 +1,173 @@
+package rssbot
+
+import (
+	"html"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		limit  int
+		isHTML bool
+		want   []string
+	}{
+		{
+			name:  "Fits",
+			text:  "Short message",
+			limit: 20,
+			want:  []string{"Short message"},
+		},
+		{
+			name:  "Paragraph break preferred",
+			text:  "First paragraph here.\n\nSecond one, with more words",
+			limit: 40,
+			want:  []string{"First paragraph here.", "Second one, with more words"},
+		},
+		{
+			name:  "Line break preferred over space",
+			text:  "one two three\nfour five six seven",
+			limit: 20,
+			want:  []string{"one two three", "four five six seven"},
+		},
+		{
+			name:  "Word boundary",
+			text:  "alpha beta gamma delta",
+			limit: 12,
+			want:  []string{"alpha beta", "gamma delta"},
+		},
+		{
+			name:  "Early break beats cutting a word",
+			text:  "a https://example.com/a/very/long/path",
+			limit: 20,
+			want:  []string{"a", "https://example.com/", "a/very/long/path"},
+		},
+		{
+			name:  "Long word cut",
+			text:  "abcdefghij",
+			limit: 4,
+			want:  []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name:  "Surrogate pairs not split",
+			text:  "😀😀😀",
+			limit: 3,
+			want:  []string{"😀", "😀", "😀"},
+		},
+		{
+			name:   "Tags reopened",
+			text:   "<b>bold words <i>and italic</i> here</b>",
+			limit:  14,
+			isHTML: true,
+			want:   []string{"<b>bold words <i>and</i></b>", "<b><i>italic</i> here</b>"},
+		},
+		{
+			name:   "Link reopened with its target",
+			text:   `<a href="https://example.com/?a=1&amp;b=2">a long link text</a>`,
+			limit:  8,
+			isHTML: true,
+			want: []string{
+				`<a href="https://example.com/?a=1&amp;b=2">a long</a>`,
+				`<a href="https://example.com/?a=1&amp;b=2">link</a>`,
+				`<a href="https://example.com/?a=1&amp;b=2">text</a>`,
+			},
+		},
+		{
+			name:   "Entities count as one character",
+			text:   "&lt;&lt;&lt;&lt;&lt;&lt;",
+			limit:  4,
+			isHTML: true,
+			want:   []string{"&lt;&lt;&lt;&lt;", "&lt;&lt;"},
+		},
+		{
+			name:   "Tags don't count",
+			text:   "<b>ab</b><i>cd</i>",
+			limit:  4,
+			isHTML: true,
+			want:   []string{"<b>ab</b><i>cd</i>"},
+		},
+		{
+			name:   "Opening tag moves to the next message",
+			text:   "abc <b>def</b>",
+			limit:  4,
+			isHTML: true,
+			want:   []string{"abc", "<b>def</b>"},
+		},
+		{
+			name:  "Plain text keeps markup",
+			text:  "<b> & </b>",
+			limit: 6,
+			want:  []string{"<b> &", "</b>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit, tt.isHTML)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+func TestSplitMessageLimits(t *testing.T) {
+	var b strings.Builder
+	for i := range 300 {
+		b.WriteString("<p>Paragraph with <b>bold &amp; <i>italic</i></b> text, 😀 and a <a href=\"https://example.com/\">link</a>.</p>\n\n")
+		if i%7 == 0 {
+			b.WriteString(strings.Repeat("x", 200))
+		}
+	}
+	text := sanitizeHTML(b.String(), "", 0)
+
+	chunks := splitMessage(text, maxMessageLength, true)
+	if len(chunks) < 2 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+
+	var visible strings.Builder
+	for i, chunk := range chunks {
+		plain := html.UnescapeString(tagPattern.ReplaceAllString(chunk, ""))
+		if n := utf16Len(plain); n > maxMessageLength {
+			t.Errorf("Chunk %d is %d characters long", i, n)
+		}
+		if got := openTags(nil, parseMessageAtoms(chunk, true)); len(got) != 0 {
+			t.Errorf("Chunk %d leaves tags open: %v", i, got)
+		}
+		visible.WriteString(plain)
+	}
+
+	squash := func(s string) string { return strings.Join(strings.Fields(s), "") }
+	if want := html.UnescapeString(tagPattern.ReplaceAllString(text, "")); squash(visible.String()) != squash(want) {
+		t.Error("Chunks don't add up to the original text")
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	tests := []struct {
+		text   string
+		limit  int
+		isHTML bool
+		want   string
+	}{
+		{"Short", 10, false, "Short"},
+		{"Some long feed title", 10, false, "Some long…"},
+		{"Привет, мир и все остальные", 12, false, "Привет, мир…"},
+		{"<b>Title</b>\n\nA long excerpt", 12, true, "<b>Title</b>…"},
+		{"<b>Title</b>\n\nA long excerpt", 20, true, "<b>Title</b>\n\nA long…"},
+		{"<b>Bold text goes on</b>", 10, true, "<b>Bold text…</b>"},
+	}
+
+	for _, tt := range tests {
+		if got := truncateMessage(tt.text, tt.limit, tt.isHTML); got != tt.want {
+			t.Errorf("truncateMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package rssbot
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Content types of FeedItem.Content.
+const (
+	contentHTML = "html"
+	contentText = "text"
+)
+
+// AtomText is an Atom text construct such as <summary> or <content>, whose
+// type attribute says whether it holds text, escaped HTML or inline XHTML.
+type AtomText struct {
+	Type     string `xml:"type,attr"`
+	Text     string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// body returns the construct's content and its content type. XHTML is
+// returned as HTML without the wrapping <div>.
+func (t *AtomText) body() (string, string) {
+	switch strings.ToLower(strings.TrimSpace(t.Type)) {
+	case "html", "text/html":
+		return strings.TrimSpace(t.Text), contentHTML
+	case "xhtml", "application/xhtml+xml":
+		return unwrapXHTMLDiv(t.InnerXML), contentHTML
+	}
+	return strings.TrimSpace(t.Text), contentText
+}
+
+// unwrapXHTMLDiv strips the <div> that must wrap inline XHTML content.
+func unwrapXHTMLDiv(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "<") || !strings.HasSuffix(s, ">") {
+		return s
+	}
+	end := strings.Index(s, ">")
+	start := strings.LastIndex(s, "</")
+	name, _, _ := strings.Cut(strings.TrimPrefix(s[1:end], "/"), " ")
+	if _, local, ok := strings.Cut(name, ":"); ok {
+		name = local
+	}
+	if name != "div" || start < end {
+		return s
+	}
+	return strings.TrimSpace(s[end+1 : start])
+}
+
+// firstImage returns the absolute URL of the first <img> in an HTML body,
+// resolved against base, or "".
+func firstImage(body, base string) string {
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag := z.Token()
+			if tag.Data != "img" {
+				continue
+			}
+			for _, attr := range tag.Attr {
+				if attr.Key == "src" && strings.TrimSpace(attr.Val) != "" {
+					return resolveURL(base, strings.TrimSpace(attr.Val))
+				}
+			}
+		}
+	}
+}
+
+// resolveURL resolves ref against base, returning ref unchanged if either
+// doesn't parse.
+func resolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+// appendTags adds the non-empty values to tags, skipping any already listed
+// regardless of case.
+func appendTags(tags []string, values ...string) []string {
+	for _, v := range values {
+		v = strings.Join(strings.Fields(v), " ")
+		if v == "" {
+			continue
+		}
+		if !slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, v) }) {
+			tags = append(tags, v)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,39 @@
+package rssbot
+
+import "testing"
+
+func TestUnwrapXHTMLDiv(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`<div xmlns="http://www.w3.org/1999/xhtml"><p>Hello</p></div>`, "<p>Hello</p>"},
+		{"\n  <xhtml:div>\n<p>Hi</p>\n</xhtml:div>\n", "<p>Hi</p>"},
+		{`<p>No wrapper</p>`, "<p>No wrapper</p>"},
+		{`plain`, "plain"},
+	}
+
+	for _, tt := range tests {
+		if got := unwrapXHTMLDiv(tt.in); got != tt.want {
+			t.Errorf("unwrapXHTMLDiv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFirstImage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`<p>Text</p><img src="/images/a.png"><img src="b.png">`, "https://example.com/images/a.png"},
+		{`<figure><img alt="x" src="https://cdn.example.com/c.jpg"/></figure>`, "https://cdn.example.com/c.jpg"},
+		{`<img src=""><img src="d.gif">`, "https://example.com/posts/d.gif"},
+		{`<p>No images</p>`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := firstImage(tt.body, "https://example.com/posts/1"); got != tt.want {
+			t.Errorf("firstImage(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
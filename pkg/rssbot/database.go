@@ -2,30 +2,81 @@ package rssbot
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
-//go:generate go run tailscale.com/cmd/viewer -type=Subscription,FeedInfo,FeedError
+//go:generate go run tailscale.com/cmd/viewer -type=Subscription,FeedInfo,FeedError,FeedState,ChatSettings
 
+// Database is a Store kept in a single JSON file. Changes made on behalf of
+// users, such as subscribing, are written out before the call returns;
+// the bookkeeping of feed checks is written at most once per saveDelay, and
+// by Flush and Close.
 type Database struct {
-	mu            sync.RWMutex
-	path          string
+	mu   sync.RWMutex
+	path string
+	// dirty is set while changes await a delayed save, which saveTimer
+	// performs unless a save or Flush comes first.
+	dirty     bool
+	saveTimer *time.Timer
+	saveDelay time.Duration
+	closed    bool
+	// migratedFrom is the schema version of the file as it was read.
+	migratedFrom int
+
+	Version int `json:"version"`
+	// Subscriptions is keyed by chat ID, then by feed URL.
 	Subscriptions map[string]map[string]*Subscription `json:"subscriptions"`
 	FeedErrors    map[string]*FeedError               `json:"feed_errors"`
+	FeedStates    map[string]*FeedState               `json:"feed_states"`
+	Chats         map[string]*ChatSettings            `json:"chats"`
 }
 
+// Subscription is a chat's subscription to a feed. A chat has at most one
+// subscription to each feed, whoever in it subscribed.
 type Subscription struct {
-	UserID       int64    `json:"user_id"`
-	ChatID       int64    `json:"chat_id"`
-	FeedURL      string   `json:"feed_url"`
-	FeedInfo     FeedInfo `json:"feed_info"`
-	LastChecked  string   `json:"last_checked"`
-	LastItemGUID string   `json:"last_item_guid"`
+	ChatID  int64  `json:"chat_id"`
+	FeedURL string `json:"feed_url"`
+	// CreatedBy is the ID of the user who subscribed the chat.
+	CreatedBy   int64    `json:"created_by"`
+	FeedInfo    FeedInfo `json:"feed_info"`
+	LastChecked string   `json:"last_checked"`
+	// Broken is set once the feed has failed Config.MaxFeedErrors checks
+	// in a row and the chat has been told about it.
+	Broken bool `json:"broken,omitempty"`
+	// ErrorNoticeAt is when the chat was last warned that the feed keeps
+	// failing, and SnoozedUntil suppresses those warnings until then.
+	ErrorNoticeAt string `json:"error_notice_at,omitempty"`
+	SnoozedUntil  string `json:"snoozed_until,omitempty"`
+	// Unreachable is set once Telegram refused to deliver to the chat at
+	// all, e.g. because the bot was blocked or removed from it. Such
+	// subscriptions aren't checked until the chat is heard from again.
+	Unreachable bool `json:"unreachable,omitempty"`
+	// SeenItems holds the identities (see itemID) of items already
+	// handled for this subscription, least recently seen first.
+	SeenItems []string `json:"seen_items"`
+	// UndeliveredChecks counts the checks in a row that left items unsent
+	// to the chat because sending them failed; see maxDeliveryRetries.
+	UndeliveredChecks int `json:"undelivered_checks,omitempty"`
+	// Initialized is set by the first successful check of the feed, which
+	// marks the items it holds as seen without sending them.
+	Initialized bool `json:"initialized,omitempty"`
+	// Template is the message template for this feed's items, overriding
+	// the chat's; see renderItem.
+	Template string `json:"template,omitempty"`
 }
 
+// maxSeenItems bounds Subscription.SeenItems. Identities that haven't
+// appeared in the feed for longest are evicted first; the set is never
+// trimmed below the number of items the feed currently lists.
+const maxSeenItems = 500
+
 type FeedInfo struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
@@ -40,122 +91,576 @@ type FeedError struct {
 	FirstErrorAt string `json:"first_error_at"`
 }
 
+// FeedState is what the bot remembers about a feed itself, shared by all of
+// its subscribers.
+type FeedState struct {
+	FeedURL string `json:"feed_url"`
+	// ETag and LastModified are the cache validators from the last
+	// successful fetch, sent back as If-None-Match and If-Modified-Since.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// TTL (minutes), SkipHours and SkipDays are the publisher's polling
+	// hints from the RSS channel; MaxAge (seconds) comes from the
+	// Cache-Control header of the last response.
+	TTL       int      `json:"ttl,omitempty"`
+	SkipHours []int    `json:"skip_hours,omitempty"`
+	SkipDays  []string `json:"skip_days,omitempty"`
+	MaxAge    int      `json:"max_age,omitempty"`
+	// Interval is the adaptive polling interval in seconds and NextCheck
+	// the time the feed is next due; see scheduleNextCheck.
+	Interval  int64  `json:"interval,omitempty"`
+	NextCheck string `json:"next_check,omitempty"`
+	// FailedProbe is a URL the feed declared as its own that didn't serve
+	// the feed when tried. It isn't tried again while the feed declares it.
+	FailedProbe string `json:"failed_probe,omitempty"`
+	// MovedTo is set by fetchFeed to the URL the feed permanently
+	// redirected to. It is acted upon right away and never persisted.
+	MovedTo string `json:"-"`
+}
+
+// ChatSettings holds a chat's preferences.
+type ChatSettings struct {
+	ChatID int64 `json:"chat_id"`
+	// Timezone is the IANA name of the zone dates are shown in, or empty
+	// for UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// Template is the message template for the chat's feeds, or empty for
+	// defaultTemplate.
+	Template string `json:"template,omitempty"`
+}
+
+// maxBackups is the number of previous versions of the database kept as
+// path.bak.1 (the newest) to path.bak.N.
+const maxBackups = 3
+
+// errEmptyDatabase is returned by readDatabaseFile for an empty file.
+var errEmptyDatabase = errors.New("database file is empty")
+
+// NewDatabase loads the database at path, or starts an empty one if the
+// file doesn't exist or is empty. Files from older schema versions are
+// migrated and saved right away; files from newer ones are refused. A file
+// that can't be read or parsed, or an empty file that has backups, which is
+// what a crash during a write used to leave behind, is replaced by the
+// newest backup that loads.
 func NewDatabase(path string) (*Database, error) {
-	db := &Database{
+	db, err := readDatabaseFile(path)
+	switch {
+	case err == nil:
+		if err := db.finishMigration(path); err != nil {
+			return nil, err
+		}
+		return db, nil
+	case errors.Is(err, errNewerSchema):
+		return nil, err
+	case errors.Is(err, fs.ErrNotExist):
+		return newDatabase(path), nil
+	case errors.Is(err, errEmptyDatabase) && !hasBackups(path):
+		return newDatabase(path), nil
+	}
+
+	for i := 1; i <= maxBackups; i++ {
+		backup := backupPath(path, i)
+		recovered, backupErr := readDatabaseFile(backup)
+		if backupErr != nil {
+			if !errors.Is(backupErr, fs.ErrNotExist) {
+				log.Printf("Database backup %s is unusable too: %v", backup, backupErr)
+			}
+			continue
+		}
+
+		// Keep the broken file out of the backup rotation for inspection.
+		corrupt := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+		if renameErr := os.Rename(path, corrupt); renameErr != nil {
+			log.Printf("Failed to set aside broken database %s: %v", path, renameErr)
+			corrupt = path
+		}
+		written := "an unknown time"
+		if info, statErr := os.Stat(backup); statErr == nil {
+			written = info.ModTime().Format(time.RFC3339)
+		}
+		log.Printf("WARNING: database %s is unusable (%v); it was moved to %s and the backup %s from %s loaded instead. "+
+			"Changes made after the backup was written are lost.",
+			path, err, corrupt, backup, written)
+
+		recovered.path = path
+		if err := recovered.finishMigration(backup); err != nil {
+			return nil, err
+		}
+		return recovered, nil
+	}
+
+	return nil, err
+}
+
+// saveDelay is how long Database waits to write changes that don't need
+// to be saved right away, so that a feed check's many updates are batched.
+const saveDelay = 10 * time.Second
+
+func newDatabase(path string) *Database {
+	return &Database{
 		path:          path,
+		saveDelay:     saveDelay,
+		migratedFrom:  schemaVersion,
+		Version:       schemaVersion,
 		Subscriptions: make(map[string]map[string]*Subscription),
 		FeedErrors:    make(map[string]*FeedError),
+		FeedStates:    make(map[string]*FeedState),
+		Chats:         make(map[string]*ChatSettings),
 	}
+}
 
-	if _, err := os.Stat(path); err == nil {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read database: %w", err)
-		}
+// readDatabaseFile loads the database stored at path, migrating it to
+// schemaVersion in memory.
+func readDatabaseFile(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read database: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errEmptyDatabase
+	}
 
-		if len(data) > 0 {
-			if err := json.Unmarshal(data, db); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal database: %w", err)
-			}
-		}
+	data, version, err := migrateDatabase(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal database: %w", err)
 	}
 
+	db := newDatabase(path)
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal database: %w", err)
+	}
+	db.migratedFrom = version
 	return db, nil
 }
 
+// finishMigration saves a database that readDatabaseFile migrated from the
+// file original, first keeping that file as it was as path.v<version> for
+// downgrades. original is the database's own path unless it was recovered
+// from a backup.
+func (db *Database) finishMigration(original string) error {
+	if db.migratedFrom == schemaVersion {
+		return nil
+	}
+
+	old := fmt.Sprintf("%s.v%d", db.path, db.migratedFrom)
+	if err := linkOrCopy(original, old); err != nil {
+		return fmt.Errorf("failed to keep a copy of the database before migrating it: %w", err)
+	}
+	log.Printf("Upgraded database %s from schema version %d to %d; the old file is kept as %s",
+		db.path, db.migratedFrom, schemaVersion, old)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.save()
+}
+
+// Flush writes out changes awaiting a delayed save.
+func (db *Database) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.dirty {
+		return nil
+	}
+	return db.save()
+}
+
+// Close flushes the database. Changes made after Close are saved right
+// away.
+func (db *Database) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.closed = true
+	if !db.dirty {
+		return nil
+	}
+	return db.save()
+}
+
+// save writes the database out now. db.mu must be held.
 func (db *Database) save() error {
 	data, err := json.MarshalIndent(db, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal database: %w", err)
 	}
 
-	if err := os.WriteFile(db.path, data, 0644); err != nil {
+	if err := writeDatabaseFile(db.path, data); err != nil {
+		// Stay dirty so that the next save or Flush retries.
+		db.dirty = true
 		return fmt.Errorf("failed to write database: %w", err)
 	}
 
+	db.dirty = false
+	if db.saveTimer != nil {
+		db.saveTimer.Stop()
+		db.saveTimer = nil
+	}
+	return nil
+}
+
+// saveLater marks the database as changed and schedules a save within
+// saveDelay. db.mu must be held.
+func (db *Database) saveLater() error {
+	if db.closed || db.saveDelay <= 0 {
+		return db.save()
+	}
+
+	db.dirty = true
+	if db.saveTimer == nil {
+		db.saveTimer = time.AfterFunc(db.saveDelay, db.delayedSave)
+	}
 	return nil
 }
 
+func (db *Database) delayedSave() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.saveTimer = nil
+	if !db.dirty {
+		return
+	}
+	if err := db.save(); err != nil {
+		log.Printf("Failed to save database: %v", err)
+	}
+}
+
+// writeDatabaseFile replaces the file at path with data without ever
+// leaving a partial file behind: data is written and synced to a temporary
+// file that is then renamed over path. The previous version is kept as the
+// newest backup.
+func writeDatabaseFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := rotateBackups(path); err != nil {
+		log.Printf("Failed to back up database %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable. Not every platform can sync a
+	// directory, so failing to is not an error.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// rotateBackups shifts the backups of path one generation back, dropping
+// the oldest, and makes the current file, unless it is empty, the newest.
+// The current file is hard-linked rather than moved, so path exists
+// throughout.
+func rotateBackups(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.Size() == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(path, i), backupPath(path, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return linkOrCopy(path, backupPath(path, 1))
+}
+
+// linkOrCopy hard-links src to dst, replacing dst, or copies src where hard
+// links aren't supported.
+func linkOrCopy(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+func backupPath(path string, generation int) string {
+	return fmt.Sprintf("%s.bak.%d", path, generation)
+}
+
+func hasBackups(path string) bool {
+	for i := 1; i <= maxBackups; i++ {
+		if _, err := os.Stat(backupPath(path, i)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (db *Database) AddSubscription(sub *Subscription) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	userKey := fmt.Sprintf("%d", sub.UserID)
-	if db.Subscriptions[userKey] == nil {
-		db.Subscriptions[userKey] = make(map[string]*Subscription)
+	chatKey := fmt.Sprintf("%d", sub.ChatID)
+	if db.Subscriptions[chatKey] == nil {
+		db.Subscriptions[chatKey] = make(map[string]*Subscription)
 	}
 
-	if _, exists := db.Subscriptions[userKey][sub.FeedURL]; exists {
+	if _, exists := db.Subscriptions[chatKey][sub.FeedURL]; exists {
 		return fmt.Errorf("already subscribed to this feed")
 	}
 
 	sub.LastChecked = time.Now().Format(time.RFC3339)
-	db.Subscriptions[userKey][sub.FeedURL] = sub
+	db.Subscriptions[chatKey][sub.FeedURL] = sub
 
 	return db.save()
 }
 
-func (db *Database) RemoveSubscription(userID int64, feedURL string) error {
+func (db *Database) RemoveSubscription(chatID int64, feedURL string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	userKey := fmt.Sprintf("%d", userID)
-	if userSubs, ok := db.Subscriptions[userKey]; ok {
-		delete(userSubs, feedURL)
-		if len(userSubs) == 0 {
-			delete(db.Subscriptions, userKey)
+	chatKey := fmt.Sprintf("%d", chatID)
+	if chatSubs, ok := db.Subscriptions[chatKey]; ok {
+		delete(chatSubs, feedURL)
+		if len(chatSubs) == 0 {
+			delete(db.Subscriptions, chatKey)
 		}
 	}
 
+	if !db.hasSubscribers(feedURL) {
+		delete(db.FeedStates, feedURL)
+	}
+
 	return db.save()
 }
 
-func (db *Database) GetUserSubscriptions(userID int64) ([]*Subscription, error) {
+func (db *Database) hasSubscribers(feedURL string) bool {
+	for _, chatSubs := range db.Subscriptions {
+		if _, ok := chatSubs[feedURL]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *Database) GetChatSubscriptions(chatID int64) ([]*Subscription, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	userKey := fmt.Sprintf("%d", userID)
-	userSubs, ok := db.Subscriptions[userKey]
+	chatKey := fmt.Sprintf("%d", chatID)
+	chatSubs, ok := db.Subscriptions[chatKey]
 	if !ok {
 		return []*Subscription{}, nil
 	}
 
-	subs := make([]*Subscription, 0, len(userSubs))
-	for _, sub := range userSubs {
-		subs = append(subs, sub)
+	subs := make([]*Subscription, 0, len(chatSubs))
+	for _, sub := range chatSubs {
+		subs = append(subs, sub.Clone())
 	}
 
 	return subs, nil
 }
 
+// GetAllSubscriptions returns copies of every subscription, so callers may
+// use them while the database is concurrently updated.
 func (db *Database) GetAllSubscriptions() ([]*Subscription, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	var subs []*Subscription
-	for _, userSubs := range db.Subscriptions {
-		for _, sub := range userSubs {
-			subs = append(subs, sub)
+	for _, chatSubs := range db.Subscriptions {
+		for _, sub := range chatSubs {
+			subs = append(subs, sub.Clone())
 		}
 	}
 
 	return subs, nil
 }
 
-func (db *Database) UpdateLastChecked(userID int64, feedURL string, lastItemGUID string) error {
+// UpdateLastChecked records a completed check of feedURL and marks seenIDs
+// as seen for the subscription.
+func (db *Database) UpdateLastChecked(chatID int64, feedURL string, seenIDs []string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	userKey := fmt.Sprintf("%d", userID)
-	if sub, ok := db.Subscriptions[userKey][feedURL]; ok {
+	chatKey := fmt.Sprintf("%d", chatID)
+	if sub, ok := db.Subscriptions[chatKey][feedURL]; ok {
 		sub.LastChecked = time.Now().Format(time.RFC3339)
-		sub.LastItemGUID = lastItemGUID
+		sub.Initialized = true
+		sub.SeenItems = addSeenItems(sub.SeenItems, seenIDs, max(maxSeenItems, len(seenIDs)))
+		return db.saveLater()
+	}
+
+	return fmt.Errorf("subscription not found")
+}
+
+// SetUndeliveredChecks sets the subscription's UndeliveredChecks.
+func (db *Database) SetUndeliveredChecks(chatID int64, feedURL string, checks int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatKey := fmt.Sprintf("%d", chatID)
+	if sub, ok := db.Subscriptions[chatKey][feedURL]; ok {
+		sub.UndeliveredChecks = checks
+		return db.saveLater()
+	}
+
+	return fmt.Errorf("subscription not found")
+}
+
+// addSeenItems moves ids to the most recent end of seen, then evicts the
+// least recently seen identities until at most limit remain.
+func addSeenItems(seen, ids []string, limit int) []string {
+	fresh := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		fresh[id] = true
+	}
+
+	result := make([]string, 0, len(seen)+len(ids))
+	for _, id := range seen {
+		if !fresh[id] {
+			result = append(result, id)
+		}
+	}
+	for _, id := range ids {
+		if fresh[id] {
+			result = append(result, id)
+			delete(fresh, id)
+		}
+	}
+
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// SetFeedBroken sets the Broken flag on every subscription to feedURL and
+// returns copies of the subscriptions whose flag changed.
+func (db *Database) SetFeedBroken(feedURL string, broken bool) ([]*Subscription, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var changed []*Subscription
+	for _, chatSubs := range db.Subscriptions {
+		if sub, ok := chatSubs[feedURL]; ok && sub.Broken != broken {
+			sub.Broken = broken
+			changed = append(changed, sub.Clone())
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	return changed, db.saveLater()
+}
+
+// SetChatUnreachable sets the Unreachable flag on every subscription of
+// chatID and returns copies of the subscriptions whose flag changed.
+func (db *Database) SetChatUnreachable(chatID int64, unreachable bool) ([]*Subscription, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var changed []*Subscription
+	for _, sub := range db.Subscriptions[fmt.Sprintf("%d", chatID)] {
+		if sub.Unreachable != unreachable {
+			sub.Unreachable = unreachable
+			changed = append(changed, sub.Clone())
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	return changed, db.save()
+}
+
+func (db *Database) RecordErrorNotice(chatID int64, feedURL string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatKey := fmt.Sprintf("%d", chatID)
+	if sub, ok := db.Subscriptions[chatKey][feedURL]; ok {
+		sub.ErrorNoticeAt = time.Now().Format(time.RFC3339)
+		return db.saveLater()
+	}
+
+	return fmt.Errorf("subscription not found")
+}
+
+func (db *Database) SnoozeErrorNotices(chatID int64, feedURL string, until time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatKey := fmt.Sprintf("%d", chatID)
+	if sub, ok := db.Subscriptions[chatKey][feedURL]; ok {
+		sub.SnoozedUntil = until.Format(time.RFC3339)
 		return db.save()
 	}
 
 	return fmt.Errorf("subscription not found")
 }
 
+// MoveFeed re-keys every subscription, error and state of oldURL under
+// newURL and returns copies of the moved subscriptions. A subscriber that
+// already follows newURL just loses the old subscription.
+func (db *Database) MoveFeed(oldURL, newURL string) ([]*Subscription, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var moved []*Subscription
+	for _, chatSubs := range db.Subscriptions {
+		sub, ok := chatSubs[oldURL]
+		if !ok {
+			continue
+		}
+		delete(chatSubs, oldURL)
+		if _, exists := chatSubs[newURL]; exists {
+			continue
+		}
+		sub.FeedURL = newURL
+		chatSubs[newURL] = sub
+		moved = append(moved, sub.Clone())
+	}
+
+	if feedErr, ok := db.FeedErrors[oldURL]; ok {
+		delete(db.FeedErrors, oldURL)
+		feedErr.FeedURL = newURL
+		db.FeedErrors[newURL] = feedErr
+	}
+	if state, ok := db.FeedStates[oldURL]; ok {
+		delete(db.FeedStates, oldURL)
+		if _, exists := db.FeedStates[newURL]; !exists {
+			state.FeedURL = newURL
+			db.FeedStates[newURL] = state
+		}
+	}
+
+	return moved, db.save()
+}
+
 func (db *Database) RecordFeedError(feedURL string, err error) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -173,15 +678,18 @@ func (db *Database) RecordFeedError(feedURL string, err error) error {
 	feedErr.LastError = err.Error()
 	feedErr.LastErrorAt = time.Now().Format(time.RFC3339)
 
-	return db.save()
+	return db.saveLater()
 }
 
 func (db *Database) ClearFeedError(feedURL string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if _, exists := db.FeedErrors[feedURL]; !exists {
+		return nil
+	}
 	delete(db.FeedErrors, feedURL)
-	return db.save()
+	return db.saveLater()
 }
 
 func (db *Database) GetFeedError(feedURL string) (*FeedError, bool) {
@@ -189,5 +697,72 @@ func (db *Database) GetFeedError(feedURL string) (*FeedError, bool) {
 	defer db.mu.RUnlock()
 
 	feedErr, exists := db.FeedErrors[feedURL]
-	return feedErr, exists
+	return feedErr.Clone(), exists
+}
+
+func (db *Database) GetFeedState(feedURL string) (*FeedState, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	state, exists := db.FeedStates[feedURL]
+	return state.Clone(), exists
+}
+
+func (db *Database) UpdateFeedState(state *FeedState) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.FeedStates[state.FeedURL] = state.Clone()
+	return db.saveLater()
+}
+
+func (db *Database) GetChatSettings(chatID int64) (*ChatSettings, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	settings, exists := db.Chats[fmt.Sprintf("%d", chatID)]
+	return settings.Clone(), exists
+}
+
+func (db *Database) SetChatTimezone(chatID int64, timezone string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatKey := fmt.Sprintf("%d", chatID)
+	settings, exists := db.Chats[chatKey]
+	if !exists {
+		settings = &ChatSettings{ChatID: chatID}
+		db.Chats[chatKey] = settings
+	}
+	settings.Timezone = timezone
+
+	return db.save()
+}
+
+func (db *Database) SetChatTemplate(chatID int64, template string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatKey := fmt.Sprintf("%d", chatID)
+	settings, exists := db.Chats[chatKey]
+	if !exists {
+		settings = &ChatSettings{ChatID: chatID}
+		db.Chats[chatKey] = settings
+	}
+	settings.Template = template
+
+	return db.save()
+}
+
+func (db *Database) SetSubscriptionTemplate(chatID int64, feedURL, template string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatKey := fmt.Sprintf("%d", chatID)
+	if sub, ok := db.Subscriptions[chatKey][feedURL]; ok {
+		sub.Template = template
+		return db.save()
+	}
+
+	return fmt.Errorf("subscription not found")
 }
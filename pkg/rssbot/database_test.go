@@ -3,11 +3,14 @@ package rssbot
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestDatabase(t *testing.T) {
-	tmpFile, err := os.CreateTemp("", "test-db-*.json")
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-*.json")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -20,9 +23,9 @@ func TestDatabase(t *testing.T) {
 	}
 
 	sub := &Subscription{
-		UserID:  123,
-		ChatID:  456,
-		FeedURL: "https://example.com/feed.xml",
+		ChatID:    456,
+		FeedURL:   "https://example.com/feed.xml",
+		CreatedBy: 123,
 		FeedInfo: FeedInfo{
 			Title:       "Example Feed",
 			Description: "Test feed",
@@ -42,10 +45,10 @@ func TestDatabase(t *testing.T) {
 		}
 	})
 
-	t.Run("GetUserSubscriptions", func(t *testing.T) {
-		subs, err := db.GetUserSubscriptions(123)
+	t.Run("GetChatSubscriptions", func(t *testing.T) {
+		subs, err := db.GetChatSubscriptions(456)
 		if err != nil {
-			t.Errorf("Failed to get user subscriptions: %v", err)
+			t.Errorf("Failed to get chat subscriptions: %v", err)
 		}
 		if len(subs) != 1 {
 			t.Errorf("Expected 1 subscription, got %d", len(subs))
@@ -66,24 +69,30 @@ func TestDatabase(t *testing.T) {
 	})
 
 	t.Run("UpdateLastChecked", func(t *testing.T) {
-		err := db.UpdateLastChecked(123, "https://example.com/feed.xml", "guid-123")
+		err := db.UpdateLastChecked(456, "https://example.com/feed.xml", []string{"guid-2", "guid-1"})
 		if err != nil {
 			t.Errorf("Failed to update last checked: %v", err)
 		}
 
-		subs, _ := db.GetUserSubscriptions(123)
-		if subs[0].LastItemGUID != "guid-123" {
-			t.Errorf("Expected LastItemGUID to be 'guid-123', got '%s'", subs[0].LastItemGUID)
+		err = db.UpdateLastChecked(456, "https://example.com/feed.xml", []string{"guid-3", "guid-2"})
+		if err != nil {
+			t.Errorf("Failed to update last checked: %v", err)
+		}
+
+		subs, _ := db.GetChatSubscriptions(456)
+		expected := []string{"guid-1", "guid-3", "guid-2"}
+		if !slices.Equal(subs[0].SeenItems, expected) {
+			t.Errorf("Expected SeenItems to be %v, got %v", expected, subs[0].SeenItems)
 		}
 	})
 
 	t.Run("RemoveSubscription", func(t *testing.T) {
-		err := db.RemoveSubscription(123, "https://example.com/feed.xml")
+		err := db.RemoveSubscription(456, "https://example.com/feed.xml")
 		if err != nil {
 			t.Errorf("Failed to remove subscription: %v", err)
 		}
 
-		subs, _ := db.GetUserSubscriptions(123)
+		subs, _ := db.GetChatSubscriptions(456)
 		if len(subs) != 0 {
 			t.Errorf("Expected 0 subscriptions after removal, got %d", len(subs))
 		}
@@ -117,7 +126,7 @@ func TestDatabase(t *testing.T) {
 }
 
 func TestDatabasePersistence(t *testing.T) {
-	tmpFile, err := os.CreateTemp("", "test-db-persist-*.json")
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-persist-*.json")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,9 +139,9 @@ func TestDatabasePersistence(t *testing.T) {
 	}
 
 	sub := &Subscription{
-		UserID:  123,
-		ChatID:  456,
-		FeedURL: "https://example.com/feed.xml",
+		ChatID:    456,
+		FeedURL:   "https://example.com/feed.xml",
+		CreatedBy: 123,
 		FeedInfo: FeedInfo{
 			Title: "Example Feed",
 		},
@@ -145,11 +154,437 @@ func TestDatabasePersistence(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	subs, err := db2.GetUserSubscriptions(123)
+	subs, err := db2.GetChatSubscriptions(456)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if len(subs) != 1 {
 		t.Errorf("Expected 1 subscription after reload, got %d", len(subs))
 	}
-}
\ No newline at end of file
+}
+
+func TestAddSeenItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		seen     []string
+		ids      []string
+		limit    int
+		expected []string
+	}{
+		{
+			name:     "Empty set",
+			ids:      []string{"b", "a"},
+			limit:    5,
+			expected: []string{"b", "a"},
+		},
+		{
+			name:     "Seen again moves to most recent",
+			seen:     []string{"a", "b", "c"},
+			ids:      []string{"a"},
+			limit:    5,
+			expected: []string{"b", "c", "a"},
+		},
+		{
+			name:     "Evicts least recently seen",
+			seen:     []string{"a", "b", "c"},
+			ids:      []string{"d", "c"},
+			limit:    3,
+			expected: []string{"b", "d", "c"},
+		},
+		{
+			name:     "Duplicate ids",
+			seen:     []string{"a"},
+			ids:      []string{"b", "b"},
+			limit:    5,
+			expected: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := addSeenItems(tt.seen, tt.ids, tt.limit)
+			if !slices.Equal(result, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSetFeedBroken(t *testing.T) {
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-broken-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	db, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chatID := range []int64{1, 2} {
+		db.AddSubscription(&Subscription{ChatID: chatID, FeedURL: "https://example.com/feed.xml"})
+	}
+	db.AddSubscription(&Subscription{ChatID: 1, FeedURL: "https://example.com/other.xml"})
+
+	changed, err := db.SetFeedBroken("https://example.com/feed.xml", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("Expected 2 subscriptions to be marked broken, got %d", len(changed))
+	}
+
+	changed, _ = db.SetFeedBroken("https://example.com/feed.xml", true)
+	if len(changed) != 0 {
+		t.Errorf("Expected already broken subscriptions to be left alone, got %d", len(changed))
+	}
+
+	subs, _ := db.GetChatSubscriptions(1)
+	for _, sub := range subs {
+		if want := sub.FeedURL == "https://example.com/feed.xml"; sub.Broken != want {
+			t.Errorf("Broken = %v for %s, want %v", sub.Broken, sub.FeedURL, want)
+		}
+	}
+}
+
+func TestMoveFeed(t *testing.T) {
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-move-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	db, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldURL, newURL := "https://example.com/old.xml", "https://example.com/new.xml"
+	db.AddSubscription(&Subscription{ChatID: 1, FeedURL: oldURL, SeenItems: []string{"a"}})
+	db.AddSubscription(&Subscription{ChatID: 2, FeedURL: oldURL})
+	db.AddSubscription(&Subscription{ChatID: 2, FeedURL: newURL})
+	db.RecordFeedError(oldURL, fmt.Errorf("test error"))
+	db.UpdateFeedState(&FeedState{FeedURL: oldURL, ETag: `"v1"`})
+
+	moved, err := db.MoveFeed(oldURL, newURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 1 || moved[0].ChatID != 1 {
+		t.Fatalf("Expected only chat 1's subscription to move, got %v", moved)
+	}
+
+	subs, _ := db.GetChatSubscriptions(1)
+	if len(subs) != 1 || subs[0].FeedURL != newURL || !slices.Equal(subs[0].SeenItems, []string{"a"}) {
+		t.Errorf("Unexpected subscriptions for chat 1: %+v", subs)
+	}
+	subs, _ = db.GetChatSubscriptions(2)
+	if len(subs) != 1 || subs[0].FeedURL != newURL {
+		t.Errorf("Expected chat 2 to keep a single subscription, got %+v", subs)
+	}
+
+	if _, exists := db.GetFeedError(oldURL); exists {
+		t.Error("Expected feed error to be moved")
+	}
+	if feedErr, exists := db.GetFeedError(newURL); !exists || feedErr.FeedURL != newURL {
+		t.Errorf("Expected feed error under new URL, got %+v", feedErr)
+	}
+	if state, exists := db.GetFeedState(newURL); !exists || state.ETag != `"v1"` {
+		t.Errorf("Expected feed state under new URL, got %+v", state)
+	}
+}
+
+func TestChatSettings(t *testing.T) {
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	db, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists := db.GetChatSettings(456); exists {
+		t.Error("Expected no settings for a new chat")
+	}
+
+	if err := db.SetChatTimezone(456, "Europe/Berlin"); err != nil {
+		t.Fatal(err)
+	}
+
+	db2, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, exists := db2.GetChatSettings(456)
+	if !exists || settings.Timezone != "Europe/Berlin" {
+		t.Errorf("Expected timezone to persist, got %+v", settings)
+	}
+
+	if err := db2.SetChatTemplate(456, "<b>{{.Title}}</b>"); err != nil {
+		t.Fatal(err)
+	}
+	settings, _ = db2.GetChatSettings(456)
+	if settings.Template != "<b>{{.Title}}</b>" || settings.Timezone != "Europe/Berlin" {
+		t.Errorf("Expected template to be set alongside timezone, got %+v", settings)
+	}
+}
+
+func TestSetSubscriptionTemplate(t *testing.T) {
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	db, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetSubscriptionTemplate(123, "https://example.com/feed", "{{.Title}}"); err == nil {
+		t.Error("Expected error for a missing subscription")
+	}
+
+	sub := &Subscription{ChatID: 123, FeedURL: "https://example.com/feed"}
+	if err := db.AddSubscription(sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetSubscriptionTemplate(123, sub.FeedURL, "{{.Title}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	db2, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := db2.GetChatSubscriptions(123)
+	if len(subs) != 1 || subs[0].Template != "{{.Title}}" {
+		t.Errorf("Expected template to persist, got %+v", subs)
+	}
+}
+
+func TestDatabaseBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.json")
+
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range maxBackups + 2 {
+		if err := db.SetChatTimezone(int64(i), "UTC"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"db.json", "db.json.bak.1", "db.json.bak.2", "db.json.bak.3"}
+	if !slices.Equal(names, want) {
+		t.Errorf("Files = %v, want %v", names, want)
+	}
+
+	// Each backup is the database one save earlier.
+	for i := 1; i <= maxBackups; i++ {
+		backup, err := readDatabaseFile(backupPath(path, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(backup.Chats); n != maxBackups+2-i {
+			t.Errorf("Backup %d has %d chats, want %d", i, n, maxBackups+2-i)
+		}
+	}
+}
+
+func TestDatabaseRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		// backups are the contents of path.bak.1, path.bak.2, ...
+		backups   []string
+		wantChats int
+		wantErr   bool
+	}{
+		{
+			name:      "Corrupt",
+			contents:  `{"subscriptions": {`,
+			backups:   []string{`{"chats": {"1": {"chat_id": 1}}}`, `{}`},
+			wantChats: 1,
+		},
+		{
+			name:      "Truncated to nothing",
+			contents:  "",
+			backups:   []string{`{"chats": {"1": {"chat_id": 1}, "2": {"chat_id": 2}}}`},
+			wantChats: 2,
+		},
+		{
+			name:      "Newest backup corrupt too",
+			contents:  "garbage",
+			backups:   []string{"", `{"chats": {"`, `{"chats": {"3": {"chat_id": 3}}}`},
+			wantChats: 1,
+		},
+		{
+			name:     "Empty without backups",
+			contents: "",
+		},
+		{
+			name:     "No usable backup",
+			contents: "garbage",
+			backups:  []string{"more garbage"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "db.json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for i, backup := range tt.backups {
+				if err := os.WriteFile(backupPath(path, i+1), []byte(backup), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			db, err := NewDatabase(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(db.Chats) != tt.wantChats {
+				t.Errorf("Got %d chats, want %d", len(db.Chats), tt.wantChats)
+			}
+
+			// The recovered database is saved back to path, and a
+			// corrupt file is set aside rather than rotated into the
+			// backups.
+			if err := db.SetChatTimezone(9, "UTC"); err != nil {
+				t.Fatal(err)
+			}
+			if reloaded, err := NewDatabase(path); err != nil || len(reloaded.Chats) != tt.wantChats+1 {
+				t.Errorf("Reloading after save: %v", err)
+			}
+			if corrupt, _ := filepath.Glob(path + ".corrupt-*"); len(corrupt) != min(len(tt.backups), 1) {
+				t.Errorf("Expected the broken file to be set aside, found %v", corrupt)
+			}
+		})
+	}
+}
+
+func TestDatabaseDelayedSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.saveDelay = time.Hour
+
+	onDisk := func() *Database {
+		t.Helper()
+		saved, err := NewDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saved
+	}
+
+	// Changes users make are saved right away.
+	sub := &Subscription{ChatID: 1, FeedURL: "https://example.com/feed"}
+	if err := db.AddSubscription(sub); err != nil {
+		t.Fatal(err)
+	}
+	if subs, _ := onDisk().GetChatSubscriptions(1); len(subs) != 1 {
+		t.Fatal("Expected the subscription to be saved immediately")
+	}
+
+	// Feed check bookkeeping waits for the timer or a flush.
+	if err := db.UpdateLastChecked(1, sub.FeedURL, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordFeedError(sub.FeedURL, fmt.Errorf("timeout")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := onDisk().GetFeedError(sub.FeedURL); ok {
+		t.Error("Expected the feed error not to be saved yet")
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	saved := onDisk()
+	if _, ok := saved.GetFeedError(sub.FeedURL); !ok {
+		t.Error("Expected Flush to save the feed error")
+	}
+	if subs, _ := saved.GetChatSubscriptions(1); !slices.Equal(subs[0].SeenItems, []string{"a"}) {
+		t.Errorf("Expected Flush to save seen items, got %v", subs[0].SeenItems)
+	}
+
+	// A user change also saves pending bookkeeping.
+	db.ClearFeedError(sub.FeedURL)
+	if err := db.SetChatTimezone(1, "UTC"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := onDisk().GetFeedError(sub.FeedURL); ok {
+		t.Error("Expected the cleared feed error to be saved with the user change")
+	}
+
+	// Unchanged data isn't written at all.
+	db.ClearFeedError(sub.FeedURL)
+	if db.dirty {
+		t.Error("Expected clearing a missing feed error not to dirty the database")
+	}
+
+	// The timer saves eventually.
+	db.mu.Lock()
+	db.saveDelay = 10 * time.Millisecond
+	db.mu.Unlock()
+	if err := db.UpdateFeedState(&FeedState{FeedURL: sub.FeedURL, ETag: `"x"`}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if state, ok := onDisk().GetFeedState(sub.FeedURL); ok && state.ETag == `"x"` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the delayed save to happen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Close saves pending changes, and later changes are saved at once.
+	db.mu.Lock()
+	db.saveDelay = time.Hour
+	db.mu.Unlock()
+	db.RecordFeedError(sub.FeedURL, fmt.Errorf("boom"))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := onDisk().GetFeedError(sub.FeedURL); !ok {
+		t.Error("Expected Close to save pending changes")
+	}
+	db.ClearFeedError(sub.FeedURL)
+	if _, ok := onDisk().GetFeedError(sub.FeedURL); ok {
+		t.Error("Expected changes after Close to be saved immediately")
+	}
+}
@@ -0,0 +1,136 @@
+package rssbot
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// dateLayouts are tried in order by parseDate, after normalizeDate has
+// dropped the weekday and commas and turned zone names into offsets.
+var dateLayouts = []string{
+	// RFC 822 / RFC 1123 and their common variants.
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05",
+	"2 January 2006 15:04:05",
+	"2 Jan 2006",
+	"2 January 2006",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2 2006 15:04:05",
+	"January 2 2006 15:04:05 -0700",
+	"January 2 2006",
+	"Jan 2 2006",
+
+	// RFC 3339 / ISO 8601 and their common variants.
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// zoneOffsets maps the zone names RFC 822 allows, plus UTC, to offsets.
+// time.Parse only knows the abbreviations of the local zone and gives the
+// rest a zero offset.
+var zoneOffsets = map[string]string{
+	"UT":  "+0000",
+	"UTC": "+0000",
+	"GMT": "+0000",
+	"Z":   "+0000",
+	"EST": "-0500",
+	"EDT": "-0400",
+	"CST": "-0600",
+	"CDT": "-0500",
+	"MST": "-0700",
+	"MDT": "-0600",
+	"PST": "-0800",
+	"PDT": "-0700",
+}
+
+// parseDate parses a feed date in any of the formats found in the wild:
+// RFC 822 and RFC 1123 with or without weekday, seconds or century, RFC 3339
+// and ISO 8601 variants, and zone names instead of offsets. Dates without a
+// zone are taken to be UTC. It returns the zero Time if value can't be
+// parsed.
+func parseDate(value string) time.Time {
+	value = normalizeDate(value)
+	if value == "" {
+		return time.Time{}
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// normalizeDate collapses whitespace, strips a leading weekday and a trailing
+// parenthesised comment such as "(UTC)", removes commas and replaces a
+// trailing zone name by its offset.
+func normalizeDate(value string) string {
+	if i := strings.LastIndex(value, "("); i > 0 && strings.HasSuffix(strings.TrimSpace(value), ")") {
+		value = value[:i]
+	}
+	fields := strings.Fields(strings.ReplaceAll(value, ",", " "))
+	if len(fields) > 0 && isWeekday(fields[0]) {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	if offset, ok := zoneOffsets[strings.ToUpper(fields[len(fields)-1])]; ok {
+		fields[len(fields)-1] = offset
+	}
+	return strings.Join(fields, " ")
+}
+
+// isWeekday reports whether s is an English weekday name or a prefix of at
+// least three letters of one, such as "Mon" or "Thurs".
+func isWeekday(s string) bool {
+	s = strings.ToLower(strings.TrimSuffix(s, "."))
+	if len(s) < 3 {
+		return false
+	}
+	return slices.ContainsFunc([]string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}, func(day string) bool {
+		return strings.HasPrefix(day, s)
+	})
+}
+
+// firstDate returns the first of values that parses as a date, or the zero
+// Time.
+func firstDate(values ...string) time.Time {
+	for _, value := range values {
+		if t := parseDate(value); !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// sortItemsByDate orders items newest first by their publication date, as
+// newItemsSince expects. Feeds that don't date every item keep their
+// document order, since undated items can't be placed reliably.
+func sortItemsByDate(items []FeedItem) {
+	if slices.ContainsFunc(items, func(item FeedItem) bool { return item.Published.IsZero() }) {
+		return
+	}
+	slices.SortStableFunc(items, func(a, b FeedItem) int {
+		return b.Published.Compare(a.Published)
+	})
+}
+
+// formatItemDate formats an item's publication date for a message, in the
+// chat's time zone.
+func formatItemDate(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("Jan 2, 2006 15:04 MST")
+}
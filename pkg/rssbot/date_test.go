@@ -0,0 +1,86 @@
+package rssbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	want := time.Date(2025, 6, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Time
+	}{
+		{"RFC 1123", "Mon, 02 Jun 2025 15:04:05 GMT", want},
+		{"RFC 1123 numeric zone", "Mon, 02 Jun 2025 17:04:05 +0200", want},
+		{"RFC 822 two-digit year", "02 Jun 25 15:04:05 +0000", want},
+		{"Single-digit day", "Mon, 2 Jun 2025 15:04:05 UT", want},
+		{"US zone name", "Mon, 02 Jun 2025 11:04:05 EDT", want},
+		{"Wrong weekday", "Fri, 02 Jun 2025 15:04:05 GMT", want},
+		{"Full names", "Monday, 02 June 2025 15:04:05 +0000", want},
+		{"No weekday comma", "Mon 02 Jun 2025 15:04:05 GMT", want},
+		{"Colon offset", "Mon, 02 Jun 2025 17:04:05 +02:00", want},
+		{"Trailing comment", "Mon, 02 Jun 2025 15:04:05 +0000 (UTC)", want},
+		{"Extra whitespace", "  Mon,  02 Jun 2025\n15:04:05 GMT ", want},
+		{"Lowercase", "mon, 02 jun 2025 15:04:05 gmt", want},
+		{"No seconds", "Mon, 02 Jun 2025 15:04 GMT", want.Truncate(time.Minute)},
+		{"No zone", "Mon, 02 Jun 2025 15:04:05", want},
+		{"Month first", "Jun 2, 2025 15:04:05 +0000", want},
+		{"RFC 3339", "2025-06-02T15:04:05Z", want},
+		{"RFC 3339 offset", "2025-06-02T17:04:05+02:00", want},
+		{"RFC 3339 fraction", "2025-06-02T15:04:05.000Z", want},
+		{"ISO 8601 compact offset", "2025-06-02T17:04:05+0200", want},
+		{"ISO 8601 no seconds", "2025-06-02T15:04Z", want.Truncate(time.Minute)},
+		{"ISO 8601 no zone", "2025-06-02T15:04:05", want},
+		{"Space separated", "2025-06-02 15:04:05", want},
+		{"Date only", "2025-06-02", time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"Empty", "", time.Time{}},
+		{"Garbage", "yesterday", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDate(tt.value)
+			if !got.Equal(tt.want) {
+				t.Errorf("parseDate(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortItemsByDate(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 6, d, 0, 0, 0, 0, time.UTC) }
+
+	t.Run("Dated", func(t *testing.T) {
+		items := []FeedItem{{GUID: "1", Published: day(1)}, {GUID: "3", Published: day(3)}, {GUID: "2", Published: day(2)}}
+		sortItemsByDate(items)
+		if items[0].GUID != "3" || items[1].GUID != "2" || items[2].GUID != "1" {
+			t.Errorf("Unexpected order: %+v", items)
+		}
+	})
+
+	t.Run("Partly undated", func(t *testing.T) {
+		items := []FeedItem{{GUID: "1", Published: day(1)}, {GUID: "2"}, {GUID: "3", Published: day(3)}}
+		sortItemsByDate(items)
+		if items[0].GUID != "1" || items[1].GUID != "2" || items[2].GUID != "3" {
+			t.Errorf("Expected document order, got %+v", items)
+		}
+	})
+}
+
+func TestFormatItemDate(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	published := time.Date(2025, 6, 2, 15, 4, 5, 0, time.UTC)
+
+	if got, want := formatItemDate(published, time.UTC), "Jun 2, 2025 15:04 UTC"; got != want {
+		t.Errorf("formatItemDate() = %q, want %q", got, want)
+	}
+	if got, want := formatItemDate(published, loc), "Jun 2, 2025 17:04 CEST"; got != want {
+		t.Errorf("formatItemDate() = %q, want %q", got, want)
+	}
+}
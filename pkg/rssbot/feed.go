@@ -3,10 +3,13 @@ package rssbot
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,13 +17,71 @@ import (
 )
 
 type RSSFeed struct {
-	XMLName xml.Name `xml:"rss"`
+	XMLName xml.Name   `xml:"rss"`
+	Channel RSSChannel `xml:"channel"`
+}
+
+type RSSChannel struct {
+	Title string `xml:"title"`
+	// AtomLinks must precede Link: an unqualified tag also matches
+	// <atom:link>, whose empty content would overwrite the channel link.
+	AtomLinks   []AtomLink `xml:"http://www.w3.org/2005/Atom link"`
+	NewFeedURL  string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd new-feed-url"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	TTL         string     `xml:"ttl"`
+	SkipHours   []string   `xml:"skipHours>hour"`
+	SkipDays    []string   `xml:"skipDays>day"`
+	Items       []RSSItem  `xml:"item"`
+}
+
+// RDFFeed is an RSS 1.0 feed. Unlike RSS 2.0, its items are siblings of the
+// channel rather than children of it.
+type RDFFeed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# RDF"`
 	Channel struct {
-		Title       string    `xml:"title"`
-		Link        string    `xml:"link"`
-		Description string    `xml:"description"`
-		Items       []RSSItem `xml:"item"`
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
 	} `xml:"channel"`
+	Items []RDFItem `xml:"item"`
+}
+
+type RDFItem struct {
+	About       string   `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Subjects    []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+}
+
+// toRSS converts an RSS 1.0 feed into the RSS 2.0 shape the rest of the bot
+// works with. The item's rdf:about URI serves as its GUID.
+func (f *RDFFeed) toRSS() *RSSFeed {
+	rssFeed := &RSSFeed{
+		Channel: RSSChannel{
+			Title:       f.Channel.Title,
+			Link:        f.Channel.Link,
+			Description: f.Channel.Description,
+			Items:       make([]RSSItem, 0, len(f.Items)),
+		},
+	}
+	for _, item := range f.Items {
+		rssFeed.Channel.Items = append(rssFeed.Channel.Items, RSSItem{
+			Title:       item.Title,
+			Link:        strings.TrimSpace(item.Link),
+			Description: item.Description,
+			Date:        item.Date,
+			Content:     item.Content,
+			GUID:        item.About,
+			Creator:     item.Creator,
+			Subjects:    item.Subjects,
+		})
+	}
+	return rssFeed
 }
 
 type AtomFeed struct {
@@ -31,30 +92,136 @@ type AtomFeed struct {
 }
 
 type AtomLink struct {
-	Href string `xml:"href,attr"`
-	Rel  string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
 }
 
 type RSSItem struct {
-	Title       string `xml:"title"`
-	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	PubDate     string `xml:"pubDate"`
-	GUID        string `xml:"guid"`
-	Author      string `xml:"author"`
-	Creator     string `xml:"dc:creator"`
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	Description string         `xml:"description"`
+	PubDate     string         `xml:"pubDate"`
+	GUID        string         `xml:"guid"`
+	Author      string         `xml:"author"`
+	Creator     string         `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Date        string         `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Content     string         `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Categories  []RSSCategory  `xml:"category"`
+	Subjects    []string       `xml:"http://purl.org/dc/elements/1.1/ subject"`
+	Enclosures  []RSSEnclosure `xml:"enclosure"`
+	ITunesImage struct {
+		Href string `xml:"href,attr"`
+	} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	MediaElements
 }
 
 type AtomEntry struct {
 	Title   string     `xml:"title"`
 	Link    []AtomLink `xml:"link"`
-	Summary string     `xml:"summary"`
-	Content string     `xml:"content"`
-	Updated string     `xml:"updated"`
-	ID      string     `xml:"id"`
-	Author  struct {
+	Summary AtomText   `xml:"summary"`
+	// MediaElements must precede Content, which would otherwise also
+	// match <media:content>.
+	MediaElements
+	Content   AtomText `xml:"content"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	ID        string   `xml:"id"`
+	Author    struct {
 		Name string `xml:"name"`
 	} `xml:"author"`
+	Categories []AtomCategory `xml:"category"`
+}
+
+// RSSCategory is an RSS <category>. Other namespaces' category elements,
+// such as media:category, also end up here and are told apart by XMLName.
+type RSSCategory struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// AtomCategory is an Atom <category>. Term is the category itself and Label
+// an optional human-readable name for it.
+type AtomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+func init() {
+	registerFeedFormat(feedFormat{name: "rss", sniff: rootElement("rss"), parse: parseRSS})
+	registerFeedFormat(feedFormat{name: "atom", sniff: rootElement("feed"), parse: parseAtom})
+	registerFeedFormat(feedFormat{name: "rdf", sniff: rootElement("RDF"), parse: parseRDF})
+}
+
+func parseRSS(data []byte) (*Feed, error) {
+	rssFeed, err := decodeXML[RSSFeed](data)
+	if err != nil {
+		return nil, err
+	}
+	return rssFeed.toFeed(), nil
+}
+
+func parseAtom(data []byte) (*Feed, error) {
+	atomFeed, err := decodeXML[AtomFeed](data)
+	if err != nil {
+		return nil, err
+	}
+	return atomFeed.toFeed(), nil
+}
+
+func parseRDF(data []byte) (*Feed, error) {
+	rdfFeed, err := decodeXML[RDFFeed](data)
+	if err != nil {
+		return nil, err
+	}
+	return rdfFeed.toRSS().toFeed(), nil
+}
+
+func (f *RSSFeed) toFeed() *Feed {
+	channel := &f.Channel
+	feed := &Feed{
+		Info: FeedInfo{
+			Title:       channel.Title,
+			Description: channel.Description,
+			Link:        channel.Link,
+		},
+		Items:    extractRSSItems(f),
+		SelfURL:  strings.TrimSpace(channel.NewFeedURL),
+		SkipDays: channel.SkipDays,
+	}
+	if feed.SelfURL == "" {
+		feed.SelfURL = findAtomLink(channel.AtomLinks, "self")
+	}
+	feed.TTL, _ = strconv.Atoi(strings.TrimSpace(channel.TTL))
+	for _, hour := range channel.SkipHours {
+		if h, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil {
+			feed.SkipHours = append(feed.SkipHours, h)
+		}
+	}
+	return feed
+}
+
+func (f *AtomFeed) toFeed() *Feed {
+	return &Feed{
+		Info: FeedInfo{
+			Title: f.Title,
+			Link:  findAtomLink(f.Link, "alternate"),
+		},
+		Items:   extractAtomItems(f),
+		SelfURL: findAtomLink(f.Link, "self"),
+	}
+}
+
+// findAtomLink returns the href of the first link with the given rel. A
+// missing rel means "alternate".
+func findAtomLink(links []AtomLink, rel string) string {
+	for _, l := range links {
+		if l.Rel == rel || (l.Rel == "" && rel == "alternate") {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	return ""
 }
 
 func (b *Bot) findAndParseFeed(ctx context.Context, urlStr string) (string, *FeedInfo, error) {
@@ -129,11 +296,8 @@ func (b *Bot) tryFindFeedAtURL(ctx context.Context, urlStr string) (string, *Fee
 	}
 
 	contentType := resp.Header.Get("Content-Type")
-	if strings.Contains(contentType, "xml") || strings.Contains(contentType, "rss") || strings.Contains(contentType, "atom") {
-		feedInfo, err := parseFeedData(body)
-		if err == nil {
-			return urlStr, feedInfo, nil
-		}
+	if feed, err := parseFeed(body, contentType); err == nil {
+		return urlStr, feedInfo(feed), nil
 	}
 
 	if strings.Contains(contentType, "html") {
@@ -156,8 +320,8 @@ func (b *Bot) tryFindFeedAtURL(ctx context.Context, urlStr string) (string, *Fee
 				continue
 			}
 
-			if feedInfo, err := parseFeedData(feedBody); err == nil {
-				return feedURL, feedInfo, nil
+			if feed, err := parseFeed(feedBody, feedResp.Header.Get("Content-Type")); err == nil {
+				return feedURL, feedInfo(feed), nil
 			}
 		}
 	}
@@ -166,39 +330,30 @@ func (b *Bot) tryFindFeedAtURL(ctx context.Context, urlStr string) (string, *Fee
 }
 
 func parseFeedData(data []byte) (*FeedInfo, error) {
-	var rssFeed RSSFeed
-	if err := xml.Unmarshal(data, &rssFeed); err == nil {
-		title := rssFeed.Channel.Title
-		if title == "" {
-			title = "Untitled Feed"
-		}
-		return &FeedInfo{
-			Title:       title,
-			Description: rssFeed.Channel.Description,
-			Link:        rssFeed.Channel.Link,
-		}, nil
-	}
-
-	var atomFeed AtomFeed
-	if err := xml.Unmarshal(data, &atomFeed); err == nil {
-		link := ""
-		for _, l := range atomFeed.Link {
-			if l.Rel == "alternate" || l.Rel == "" {
-				link = l.Href
-				break
-			}
-		}
-		title := atomFeed.Title
-		if title == "" {
-			title = "Untitled Feed"
-		}
-		return &FeedInfo{
-			Title: title,
-			Link:  link,
-		}, nil
+	feed, err := parseFeed(data, "")
+	if err != nil {
+		return nil, err
 	}
+	return feedInfo(feed), nil
+}
 
-	return nil, fmt.Errorf("unable to parse feed")
+// feedInfo returns the subscription metadata for feed.
+func feedInfo(feed *Feed) *FeedInfo {
+	info := feed.Info
+	if info.Title == "" {
+		info.Title = "Untitled Feed"
+	}
+	return &info
+}
+
+// isFeedMediaType reports whether an HTML <link> type attribute announces a
+// feed we can parse.
+func isFeedMediaType(mediaType string) bool {
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "application/rss+xml", "application/atom+xml", "application/rdf+xml", "application/feed+json":
+		return true
+	}
+	return false
 }
 
 func findFeedURLsInHTML(htmlData []byte, baseURL string) []string {
@@ -225,7 +380,7 @@ func findFeedURLsInHTML(htmlData []byte, baseURL string) []string {
 				}
 			}
 
-			if rel == "alternate" && (feedType == "application/rss+xml" || feedType == "application/atom+xml") && href != "" {
+			if rel == "alternate" && isFeedMediaType(feedType) && href != "" {
 				feedURL, err := url.Parse(href)
 				if err == nil {
 					if !feedURL.IsAbs() {
@@ -245,39 +400,142 @@ func findFeedURLsInHTML(htmlData []byte, baseURL string) []string {
 	return feedURLs
 }
 
-func (b *Bot) fetchFeed(ctx context.Context, feedURL string) (*RSSFeed, *AtomFeed, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
+// statusError is returned by fetchFeed when the server answers with an
+// unexpected status code.
+type statusError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the server on 429 and 503
+	// responses.
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+// errNotModified is returned by fetchFeed when the server answers a
+// conditional request with 304 Not Modified.
+var errNotModified = errors.New("feed not modified")
+
+// fetchFeed downloads and parses feedURL. If state is non-nil its cache
+// validators are sent with the request, and it is updated with the
+// validators, polling hints and permanent redirect target from the response.
+func (b *Bot) fetchFeed(ctx context.Context, feedURL string, state *FeedState) (*Feed, error) {
+	// movedTo follows the redirect chain for as long as every hop is
+	// permanent.
+	movedTo, permanent := "", true
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			code := req.Response.StatusCode
+			permanent = permanent && (code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect)
+			if permanent {
+				movedTo = req.URL.String()
+			}
+			return nil
+		},
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", "RSS-Telegram-Bot/1.0")
+	if state != nil {
+		if state.ETag != "" {
+			req.Header.Set("If-None-Match", state.ETag)
+		}
+		if state.LastModified != "" {
+			req.Header.Set("If-Modified-Since", state.LastModified)
+		}
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if state != nil {
+		state.MaxAge = cacheMaxAge(resp.Header)
+		// A feed that moved with its validators answers at the new URL
+		// with 304 Not Modified.
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified {
+			state.MovedTo = movedTo
+		}
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+		statusErr := &statusError{StatusCode: resp.StatusCode}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, statusErr
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	var rssFeed RSSFeed
-	if err := xml.Unmarshal(body, &rssFeed); err == nil && (rssFeed.Channel.Title != "" || len(rssFeed.Channel.Items) > 0) {
-		return &rssFeed, nil, nil
+	feed, err := parseFeed(body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
 	}
 
-	var atomFeed AtomFeed
-	if err := xml.Unmarshal(body, &atomFeed); err == nil && len(atomFeed.Entries) > 0 {
-		return nil, &atomFeed, nil
+	if state != nil {
+		state.ETag = resp.Header.Get("ETag")
+		state.LastModified = resp.Header.Get("Last-Modified")
+		state.TTL = feed.TTL
+		state.SkipHours = feed.SkipHours
+		state.SkipDays = feed.SkipDays
+	}
+	return feed, nil
+}
+
+// declaredFeedURL returns the URL feed declares as its own, resolved
+// against feedURL, or "" if it declares none. A plain HTTP URL declared by
+// a feed served over HTTPS is ignored.
+func declaredFeedURL(feedURL string, feed *Feed) string {
+	if feed.SelfURL == "" {
+		return ""
 	}
 
-	return nil, nil, fmt.Errorf("unable to parse feed")
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(feed.SelfURL)
+	if err != nil {
+		return ""
+	}
+	resolved := base.ResolveReference(ref)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return ""
+	}
+	if base.Scheme == "https" && resolved.Scheme != "https" {
+		return ""
+	}
+	return resolved.String()
+}
+
+// sameFeed reports whether probed, fetched from the URL that feed declares
+// as its own, is the same feed: it declares that URL too, or lists some of
+// the same items.
+func sameFeed(feed, probed *Feed, declared string) bool {
+	if declaredFeedURL(declared, probed) == declared {
+		return true
+	}
+	ids := make(map[string]bool, len(feed.Items))
+	for _, item := range feed.Items {
+		ids[itemID(item)] = true
+	}
+	return slices.ContainsFunc(probed.Items, func(item FeedItem) bool { return ids[itemID(item)] })
 }
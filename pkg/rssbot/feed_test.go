@@ -2,9 +2,13 @@ package rssbot
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestParseFeedData(t *testing.T) {
@@ -72,6 +76,48 @@ func TestParseFeedData(t *testing.T) {
 				Link:  "https://example.com",
 			},
 		},
+		{
+			name: "RDF feed",
+			feedData: `<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+  <channel rdf:about="https://example.com/">
+    <title>Test RDF Feed</title>
+    <link>https://example.com</link>
+    <description>A test RDF feed</description>
+  </channel>
+  <item rdf:about="https://example.com/item1">
+    <title>Test Item</title>
+    <link>https://example.com/item1</link>
+  </item>
+</rdf:RDF>`,
+			wantErr: false,
+			wantInfo: &FeedInfo{
+				Title:       "Test RDF Feed",
+				Link:        "https://example.com",
+				Description: "A test RDF feed",
+			},
+		},
+		{
+			name: "JSON Feed",
+			feedData: `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Test JSON Feed",
+  "home_page_url": "https://example.com",
+  "description": "A test JSON feed",
+  "items": [{"id": "1", "url": "https://example.com/item1", "title": "Test Item"}]
+}`,
+			wantErr: false,
+			wantInfo: &FeedInfo{
+				Title:       "Test JSON Feed",
+				Link:        "https://example.com",
+				Description: "A test JSON feed",
+			},
+		},
+		{
+			name:     "JSON that is not a feed",
+			feedData: `{"hello": "world"}`,
+			wantErr:  true,
+		},
 		{
 			name:     "Invalid XML",
 			feedData: `not xml`,
@@ -108,19 +154,21 @@ func TestFindFeedURLsInHTML(t *testing.T) {
 <head>
   <link rel="alternate" type="application/rss+xml" href="/feed.xml" title="RSS Feed">
   <link rel="alternate" type="application/atom+xml" href="https://example.com/atom.xml" title="Atom Feed">
+  <link rel="alternate" type="application/feed+json" href="/feed.json" title="JSON Feed">
   <link rel="stylesheet" href="/style.css">
 </head>
 <body>Test</body>
 </html>`
 
 	urls := findFeedURLsInHTML([]byte(html), "https://example.com/page")
-	if len(urls) != 2 {
-		t.Errorf("Expected 2 feed URLs, got %d", len(urls))
+	if len(urls) != 3 {
+		t.Errorf("Expected 3 feed URLs, got %d", len(urls))
 	}
 
 	expectedURLs := map[string]bool{
-		"https://example.com/feed.xml": true,
-		"https://example.com/atom.xml": true,
+		"https://example.com/feed.xml":  true,
+		"https://example.com/atom.xml":  true,
+		"https://example.com/feed.json": true,
 	}
 
 	for _, url := range urls {
@@ -132,12 +180,7 @@ func TestFindFeedURLsInHTML(t *testing.T) {
 
 func TestExtractRSSItems(t *testing.T) {
 	feed := &RSSFeed{
-		Channel: struct {
-			Title       string    `xml:"title"`
-			Link        string    `xml:"link"`
-			Description string    `xml:"description"`
-			Items       []RSSItem `xml:"item"`
-		}{
+		Channel: RSSChannel{
 			Items: []RSSItem{
 				{
 					Title:  "Item 1",
@@ -155,8 +198,7 @@ func TestExtractRSSItems(t *testing.T) {
 		},
 	}
 
-	bot := &Bot{}
-	items := bot.extractRSSItems(feed)
+	items := extractRSSItems(feed)
 
 	if len(items) != 2 {
 		t.Errorf("Expected 2 items, got %d", len(items))
@@ -180,7 +222,7 @@ func TestExtractAtomItems(t *testing.T) {
 					{Href: "https://example.com/1", Rel: "alternate"},
 				},
 				ID:      "id1",
-				Summary: "Summary 1",
+				Summary: AtomText{Text: "Summary 1"},
 				Author: struct {
 					Name string `xml:"name"`
 				}{Name: "Author 1"},
@@ -191,13 +233,12 @@ func TestExtractAtomItems(t *testing.T) {
 					{Href: "https://example.com/2"},
 				},
 				ID:      "id2",
-				Content: "Content 2",
+				Content: AtomText{Text: "Content 2"},
 			},
 		},
 	}
 
-	bot := &Bot{}
-	items := bot.extractAtomItems(feed)
+	items := extractAtomItems(feed)
 
 	if len(items) != 2 {
 		t.Errorf("Expected 2 items, got %d", len(items))
@@ -289,8 +330,7 @@ func TestFetchFeedWithEmptyTitle(t *testing.T) {
 		name        string
 		feedContent string
 		contentType string
-		wantRSS     bool
-		wantAtom    bool
+		wantFormat  string
 		wantErr     bool
 	}{
 		{
@@ -306,7 +346,7 @@ func TestFetchFeedWithEmptyTitle(t *testing.T) {
   </entry>
 </feed>`,
 			contentType: "application/atom+xml",
-			wantAtom:    true,
+			wantFormat:  "atom",
 			wantErr:     false,
 		},
 		{
@@ -323,7 +363,7 @@ func TestFetchFeedWithEmptyTitle(t *testing.T) {
   </channel>
 </rss>`,
 			contentType: "application/rss+xml",
-			wantRSS:     true,
+			wantFormat:  "rss",
 			wantErr:     false,
 		},
 		{
@@ -359,27 +399,831 @@ func TestFetchFeedWithEmptyTitle(t *testing.T) {
 			defer server.Close()
 
 			bot := &Bot{}
-			rssFeed, atomFeed, err := bot.fetchFeed(context.Background(), server.URL)
+			feed, err := bot.fetchFeed(context.Background(), server.URL, nil)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("fetchFeed() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
-			if tt.wantRSS && rssFeed == nil {
-				t.Error("Expected RSS feed but got nil")
+			if tt.wantErr {
+				if feed != nil {
+					t.Error("Expected no feed but got one")
+				}
+				return
+			}
+
+			if feed.Format != tt.wantFormat {
+				t.Errorf("Expected %s feed, got %s", tt.wantFormat, feed.Format)
+			}
+		})
+	}
+}
+
+func TestNewItemsSince(t *testing.T) {
+	items := []FeedItem{
+		{GUID: "guid5"},
+		{GUID: "guid4"},
+		{GUID: "guid3"},
+		{GUID: "guid2"},
+		{GUID: "guid1"},
+	}
+
+	tests := []struct {
+		name     string
+		seen     []string
+		limit    int
+		expected []string
+	}{
+		{
+			name:     "Nothing new",
+			seen:     []string{"guid1", "guid2", "guid3", "guid4", "guid5"},
+			limit:    10,
+			expected: nil,
+		},
+		{
+			name:     "Several new items oldest first",
+			seen:     []string{"guid1", "guid2"},
+			limit:    10,
+			expected: []string{"guid3", "guid4", "guid5"},
+		},
+		{
+			name:     "Reordered and deleted items",
+			seen:     []string{"guid1", "guid3", "guid5", "guid6"},
+			limit:    10,
+			expected: []string{"guid2", "guid4"},
+		},
+		{
+			name:     "Capped to newest items",
+			seen:     []string{"guid0"},
+			limit:    2,
+			expected: []string{"guid4", "guid5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for _, id := range tt.seen {
+				seen[id] = true
+			}
+			result := newItemsSince(items, seen, tt.limit)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Expected %d items, got %d", len(tt.expected), len(result))
+			}
+			for i, item := range result {
+				if item.GUID != tt.expected[i] {
+					t.Errorf("Item %d: expected %s, got %s", i, tt.expected[i], item.GUID)
+				}
+			}
+		})
+	}
+}
+
+func TestItemID(t *testing.T) {
+	withGUID := FeedItem{GUID: "guid1", Link: "https://example.com/1", Title: "One"}
+	if id := itemID(withGUID); id != "guid1" {
+		t.Errorf("Expected GUID to be used as identity, got %s", id)
+	}
+
+	a := FeedItem{Link: "https://example.com/1", Title: "One"}
+	b := FeedItem{Link: "https://example.com/1", Title: "One (updated)"}
+	if itemID(a) == "" || itemID(a) != itemID(FeedItem{Link: a.Link, Title: a.Title}) {
+		t.Errorf("Expected stable identity for item without GUID, got %s", itemID(a))
+	}
+	if itemID(a) == itemID(b) {
+		t.Error("Expected different identities for different titles")
+	}
+}
+
+func TestFetchFeedConditionalGet(t *testing.T) {
+	feedContent := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Test RSS Feed</title>
+    <item>
+      <title>Test Item</title>
+      <guid>item1</guid>
+    </item>
+  </channel>
+</rss>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write([]byte(feedContent))
+	}))
+	defer server.Close()
+
+	bot := &Bot{}
+	state := &FeedState{FeedURL: server.URL}
+
+	feed, err := bot.fetchFeed(context.Background(), server.URL, state)
+	if err != nil || feed == nil {
+		t.Fatalf("Expected feed on first fetch, got err %v", err)
+	}
+	if state.ETag != `"v1"` || state.LastModified != "Mon, 02 Jan 2006 15:04:05 GMT" {
+		t.Errorf("Expected validators to be stored, got %+v", state)
+	}
+
+	_, err = bot.fetchFeed(context.Background(), server.URL, state)
+	if !errors.Is(err, errNotModified) {
+		t.Errorf("Expected errNotModified on conditional fetch, got %v", err)
+	}
+}
+
+func TestFetchFeedPermanentRedirect(t *testing.T) {
+	feedContent := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Test RSS Feed</title>
+    <item><title>Test Item</title><guid>item1</guid></item>
+  </channel>
+</rss>`
+
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusMovedPermanently))
+	mux.Handle("/temp", http.RedirectHandler("/new", http.StatusFound))
+	mux.Handle("/chain", http.RedirectHandler("/temp", http.StatusPermanentRedirect))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feedContent))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path        string
+		wantMovedTo string
+	}{
+		{"/new", ""},
+		{"/old", server.URL + "/new"},
+		{"/temp", ""},
+		{"/chain", server.URL + "/temp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			state := &FeedState{FeedURL: server.URL + tt.path}
+			if _, err := (&Bot{}).fetchFeed(context.Background(), state.FeedURL, state); err != nil {
+				t.Fatal(err)
+			}
+			if state.MovedTo != tt.wantMovedTo {
+				t.Errorf("MovedTo = %q, want %q", state.MovedTo, tt.wantMovedTo)
+			}
+		})
+	}
+}
+
+func TestDeclaredFeedURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		feedData string
+		want     string
+	}{
+		{
+			name: "RSS atom:link self",
+			feedData: `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>Test</title>
+    <link>https://example.com</link>
+    <atom:link href="https://example.com/new.xml" rel="self" type="application/rss+xml"/>
+    <item><guid>1</guid></item>
+  </channel>
+</rss>`,
+			want: "https://example.com/new.xml",
+		},
+		{
+			name: "iTunes new-feed-url wins",
+			feedData: `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <channel>
+    <title>Test</title>
+    <atom:link href="https://example.com/feed.xml" rel="self"/>
+    <itunes:new-feed-url>https://podcasts.example.org/feed.xml</itunes:new-feed-url>
+    <item><guid>1</guid></item>
+  </channel>
+</rss>`,
+			want: "https://podcasts.example.org/feed.xml",
+		},
+		{
+			name: "Atom relative self link",
+			feedData: `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Test</title>
+  <link href="/atom.xml" rel="self"/>
+  <link href="https://example.com/" rel="alternate"/>
+  <entry><id>1</id></entry>
+</feed>`,
+			want: "https://example.com/atom.xml",
+		},
+		{
+			name: "Downgrade to plain HTTP",
+			feedData: `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>Test</title>
+    <atom:link href="http://example.com/feed.xml" rel="self"/>
+    <item><guid>1</guid></item>
+  </channel>
+</rss>`,
+			want: "",
+		},
+		{
+			name: "No declaration",
+			feedData: `<rss version="2.0">
+  <channel>
+    <title>Test</title>
+    <item><guid>1</guid></item>
+  </channel>
+</rss>`,
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed([]byte(tt.feedData), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := declaredFeedURL("https://example.com/feed.xml", feed); got != tt.want {
+				t.Errorf("declaredFeedURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRSSChannelLinkWithAtomSelfLink(t *testing.T) {
+	info, err := parseFeedData([]byte(`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>Test</title>
+    <link>https://example.com</link>
+    <atom:link href="https://example.com/feed.xml" rel="self"/>
+  </channel>
+</rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Link != "https://example.com" {
+		t.Errorf("Link = %q, want %q", info.Link, "https://example.com")
+	}
+}
+
+func TestParseRDFFeedItems(t *testing.T) {
+	feedData := `<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel rdf:about="https://example.com/">
+    <title>Test RDF Feed</title>
+    <link>https://example.com</link>
+    <items>
+      <rdf:Seq>
+        <rdf:li rdf:resource="https://example.com/item2"/>
+        <rdf:li rdf:resource="https://example.com/item1"/>
+      </rdf:Seq>
+    </items>
+  </channel>
+  <item rdf:about="https://example.com/item2">
+    <title>Item 2</title>
+    <link>
+      https://example.com/item2
+    </link>
+    <description>Second item</description>
+    <dc:creator>Author 2</dc:creator>
+    <dc:date>2025-06-02T10:00:00Z</dc:date>
+  </item>
+  <item rdf:about="https://example.com/item1">
+    <title>Item 1</title>
+    <link>https://example.com/item1</link>
+  </item>
+</rdf:RDF>`
+
+	feed, err := parseFeed([]byte(feedData), "")
+	if err != nil || feed.Format != "rdf" {
+		t.Fatalf("Expected RDF feed, got %v", err)
+	}
+
+	items := feed.Items
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(items))
+	}
+
+	want := FeedItem{
+		Title:       "Item 2",
+		Link:        "https://example.com/item2",
+		Author:      "Author 2",
+		GUID:        "https://example.com/item2",
+		Description: "Second item",
+		Content:     "Second item",
+		ContentType: "html",
+		Published:   time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(items[0], want) {
+		t.Errorf("Item 0 = %+v, want %+v", items[0], want)
+	}
+	if items[1].GUID != "https://example.com/item1" {
+		t.Errorf("Expected GUID from rdf:about, got %q", items[1].GUID)
+	}
+}
+
+func TestParseJSONFeedItems(t *testing.T) {
+	feedData := `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Test JSON Feed",
+  "authors": [{"name": "Feed Author"}],
+  "items": [
+    {
+      "id": "https://example.com/item2",
+      "url": "https://example.com/item2",
+      "title": "Item 2",
+      "summary": "Second item",
+      "content_html": "<p>Second item body</p>",
+      "date_published": "2025-06-02T10:00:00Z",
+      "authors": [{"name": "Author 2"}]
+    },
+    {
+      "id": 1,
+      "url": "https://example.com/item1",
+      "content_text": "First item"
+    }
+  ]
+}`
+
+	feed, err := parseFeed([]byte(feedData), "application/feed+json")
+	if err != nil || feed.Format != "json" {
+		t.Fatalf("Expected JSON Feed, got %v", err)
+	}
+
+	items := feed.Items
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(items))
+	}
+
+	if items[0].GUID != "https://example.com/item2" || items[0].Author != "Author 2" || items[0].Description != "Second item" {
+		t.Errorf("Unexpected item 0: %+v", items[0])
+	}
+	if items[1].GUID != "1" || items[1].Author != "Feed Author" || items[1].Description != "First item" {
+		t.Errorf("Unexpected item 1: %+v", items[1])
+	}
+}
+
+func TestTryFindFeedAtURLJSONFeed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><link rel="alternate" type="application/feed+json" href="/feed.json"></head></html>`))
+	})
+	mux.HandleFunc("/feed.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/feed+json")
+		w.Write([]byte(`{"version": "https://jsonfeed.org/version/1.1", "title": "Test JSON Feed", "items": []}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	bot := &Bot{}
+	for _, path := range []string{"/", "/feed.json"} {
+		feedURL, info, err := bot.tryFindFeedAtURL(context.Background(), server.URL+path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if feedURL != server.URL+"/feed.json" || info.Title != "Test JSON Feed" {
+			t.Errorf("%s: got %s %+v", path, feedURL, info)
+		}
+	}
+}
+
+func TestParseRSSItemDates(t *testing.T) {
+	feedData := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>Oldest First</title>
+    <item>
+      <title>Item 1</title>
+      <guid>1</guid>
+      <pubDate>Mon, 02 Jun 2025 08:00:00 GMT</pubDate>
+    </item>
+    <item>
+      <title>Item 3</title>
+      <guid>3</guid>
+      <dc:creator>Creator 3</dc:creator>
+      <dc:date>2025-06-04T08:00:00+02:00</dc:date>
+    </item>
+    <item>
+      <title>Item 2</title>
+      <guid>2</guid>
+      <pubDate>Tue, 3 Jun 2025 08:00:00 EST</pubDate>
+    </item>
+  </channel>
+</rss>`
+
+	feed, err := parseFeed([]byte(feedData), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var guids []string
+	for _, item := range feed.Items {
+		guids = append(guids, item.GUID)
+	}
+	if want := []string{"3", "2", "1"}; !slices.Equal(guids, want) {
+		t.Errorf("Items ordered %v, want %v", guids, want)
+	}
+
+	if feed.Items[0].Author != "Creator 3" {
+		t.Errorf("Expected author from dc:creator, got %q", feed.Items[0].Author)
+	}
+	if want := time.Date(2025, 6, 4, 6, 0, 0, 0, time.UTC); !feed.Items[0].Published.Equal(want) {
+		t.Errorf("Published = %v, want %v", feed.Items[0].Published, want)
+	}
+	if want := time.Date(2025, 6, 3, 13, 0, 0, 0, time.UTC); !feed.Items[1].Published.Equal(want) {
+		t.Errorf("Published = %v, want %v", feed.Items[1].Published, want)
+	}
+}
+
+func TestParseAtomEntryDates(t *testing.T) {
+	feedData := `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Test</title>
+  <entry>
+    <id>1</id>
+    <title>Edited</title>
+    <published>2025-06-01T10:00:00Z</published>
+    <updated>2025-06-05T10:00:00Z</updated>
+  </entry>
+  <entry>
+    <id>2</id>
+    <title>Only updated</title>
+    <updated>2025-06-03T10:00:00Z</updated>
+  </entry>
+</feed>`
+
+	feed, err := parseFeed([]byte(feedData), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Items[0].GUID != "2" || feed.Items[1].GUID != "1" {
+		t.Fatalf("Expected entries ordered by publication date, got %+v", feed.Items)
+	}
+	if want := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC); !feed.Items[1].Published.Equal(want) {
+		t.Errorf("Published = %v, want %v", feed.Items[1].Published, want)
+	}
+}
+
+func TestParseBrokenFeeds(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantTitle string
+		wantItem  string
+	}{
+		{
+			name:      "Unescaped ampersand",
+			data:      `<rss version="2.0"><channel><title>News & Views</title><item><title>Q&A</title><link>https://example.com/?a=1&b=2</link></item></channel></rss>`,
+			wantTitle: "News & Views",
+			wantItem:  "Q&A",
+		},
+		{
+			name:      "HTML entities",
+			data:      `<rss version="2.0"><channel><title>Caf&eacute;&nbsp;Blog</title><item><title>&ldquo;Quoted&rdquo; &amp; escaped</title></item></channel></rss>`,
+			wantTitle: "Café Blog",
+			wantItem:  "“Quoted” & escaped",
+		},
+		{
+			name:      "Byte order mark",
+			data:      "\ufeff<?xml version=\"1.0\" encoding=\"UTF-8\"?><rss version=\"2.0\"><channel><title>BOM</title><item><title>Item</title></item></channel></rss>",
+			wantTitle: "BOM",
+			wantItem:  "Item",
+		},
+		{
+			name:      "Whitespace before declaration",
+			data:      "\n\n   <?xml version=\"1.0\"?>\n<feed xmlns=\"http://www.w3.org/2005/Atom\"><title>Atom</title><entry><title>Entry</title></entry></feed>",
+			wantTitle: "Atom",
+			wantItem:  "Entry",
+		},
+		{
+			name:      "BOM and whitespace",
+			data:      "\ufeff\r\n<?xml version=\"1.0\"?><rss version=\"2.0\"><channel><title>Both</title></channel></rss>",
+			wantTitle: "Both",
+		},
+		{
+			name:      "Control characters",
+			data:      "<rss version=\"2.0\"><channel><title>Ctrl\x08</title><item><title>Form\x0cfeed\x00</title></item></channel></rss>",
+			wantTitle: "Ctrl",
+			wantItem:  "Formfeed",
+		},
+		{
+			name:      "Unclosed HTML in description",
+			data:      `<rss version="2.0"><channel><title>Void</title><item><title>Item</title><description>Line<br>break</description></item></channel></rss>`,
+			wantTitle: "Void",
+			wantItem:  "Item",
+		},
+		{
+			name:      "Missing end tags",
+			data:      `<rss version="2.0"><channel><title>Unclosed</title><item><title>Item</item></channel></rss>`,
+			wantTitle: "Unclosed",
+			wantItem:  "Item",
+		},
+		{
+			name:      "Broken RDF",
+			data:      `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><channel><title>R&D</title></channel><item><title>One&nbsp;item</title></item></rdf:RDF>`,
+			wantTitle: "R&D",
+			wantItem:  "One item",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed([]byte(tt.data), "")
+			if err != nil {
+				t.Fatalf("parseFeed() error = %v", err)
 			}
+			if feed.Info.Title != tt.wantTitle {
+				t.Errorf("Title = %q, want %q", feed.Info.Title, tt.wantTitle)
+			}
+			if tt.wantItem == "" {
+				return
+			}
+			if len(feed.Items) == 0 || feed.Items[0].Title != tt.wantItem {
+				t.Errorf("Items = %+v, want first item %q", feed.Items, tt.wantItem)
+			}
+		})
+	}
+}
+
+func TestParseFeedMedia(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		wantMedia     []Media
+		wantThumbnail string
+	}{
+		{
+			name: "Podcast",
+			data: `<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:media="http://search.yahoo.com/mrss/">
+  <channel>
+    <title>Podcast</title>
+    <item>
+      <title>Episode 1</title>
+      <enclosure url="https://example.com/ep1.mp3" length="12345" type="audio/mpeg"/>
+      <media:content url="https://example.com/ep1.mp3" type="audio/mpeg"/>
+      <itunes:image href="https://example.com/ep1.jpg"/>
+    </item>
+  </channel>
+</rss>`,
+			wantMedia:     []Media{{URL: "https://example.com/ep1.mp3", Type: "audio/mpeg", Length: 12345}},
+			wantThumbnail: "https://example.com/ep1.jpg",
+		},
+		{
+			name: "Media RSS",
+			data: `<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/">
+  <channel>
+    <title>Comic</title>
+    <item>
+      <title>Strip 1</title>
+      <media:content url="https://example.com/strip1.png" medium="image" fileSize="2048"/>
+      <media:thumbnail url="https://example.com/strip1-thumb.png"/>
+    </item>
+  </channel>
+</rss>`,
+			wantMedia:     []Media{{URL: "https://example.com/strip1.png", Medium: "image", Length: 2048}},
+			wantThumbnail: "https://example.com/strip1-thumb.png",
+		},
+		{
+			name: "Atom enclosure",
+			data: `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom Podcast</title>
+  <entry>
+    <id>1</id>
+    <title>Episode 1</title>
+    <link href="https://example.com/ep1"/>
+    <link rel="enclosure" href="https://example.com/ep1.ogg" type="audio/ogg" length="999"/>
+    <content type="html">Show notes</content>
+  </entry>
+</feed>`,
+			wantMedia: []Media{{URL: "https://example.com/ep1.ogg", Type: "audio/ogg", Length: 999}},
+		},
+		{
+			name: "Atom media group",
+			data: `<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+  <title>Videos</title>
+  <entry>
+    <id>1</id>
+    <title>Video 1</title>
+    <media:group>
+      <media:title>Video 1</media:title>
+      <media:content url="https://example.com/v/1" type="application/x-shockwave-flash"/>
+      <media:thumbnail url="https://example.com/v/1.jpg"/>
+    </media:group>
+  </entry>
+</feed>`,
+			wantMedia:     []Media{{URL: "https://example.com/v/1", Type: "application/x-shockwave-flash"}},
+			wantThumbnail: "https://example.com/v/1.jpg",
+		},
+		{
+			name: "JSON Feed attachments",
+			data: `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "JSON Podcast",
+  "items": [{
+    "id": "1",
+    "title": "Episode 1",
+    "image": "https://example.com/ep1.jpg",
+    "attachments": [{"url": "https://example.com/ep1.m4a", "mime_type": "audio/x-m4a", "size_in_bytes": 4096}]
+  }]
+}`,
+			wantMedia:     []Media{{URL: "https://example.com/ep1.m4a", Type: "audio/x-m4a", Length: 4096}},
+			wantThumbnail: "https://example.com/ep1.jpg",
+		},
+	}
 
-			if tt.wantAtom && atomFeed == nil {
-				t.Error("Expected Atom feed but got nil")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed([]byte(tt.data), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			item := feed.Items[0]
+			if !reflect.DeepEqual(item.Media, tt.wantMedia) {
+				t.Errorf("Media = %+v, want %+v", item.Media, tt.wantMedia)
+			}
+			if item.Thumbnail != tt.wantThumbnail {
+				t.Errorf("Thumbnail = %q, want %q", item.Thumbnail, tt.wantThumbnail)
 			}
+		})
+	}
+}
 
-			if !tt.wantRSS && rssFeed != nil {
-				t.Error("Expected no RSS feed but got one")
+func TestParseFeedContent(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		wantDescription string
+		wantContent     string
+		wantType        string
+		wantThumbnail   string
+	}{
+		{
+			name: "RSS content:encoded",
+			data: `<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+  <channel>
+    <title>Blog</title>
+    <item>
+      <title>Post</title>
+      <link>https://example.com/post</link>
+      <description>Short summary</description>
+      <content:encoded><![CDATA[<p>The <b>full</b> post.</p><img src="/img/hero.jpg">]]></content:encoded>
+    </item>
+  </channel>
+</rss>`,
+			wantDescription: "Short summary",
+			wantContent:     `<p>The <b>full</b> post.</p><img src="/img/hero.jpg">`,
+			wantType:        contentHTML,
+			wantThumbnail:   "https://example.com/img/hero.jpg",
+		},
+		{
+			name:            "RSS description only",
+			data:            `<rss version="2.0"><channel><title>Blog</title><item><title>Post</title><description>&lt;p&gt;Escaped HTML&lt;/p&gt;</description></item></channel></rss>`,
+			wantDescription: "<p>Escaped HTML</p>",
+			wantContent:     "<p>Escaped HTML</p>",
+			wantType:        contentHTML,
+		},
+		{
+			name: "Atom XHTML content",
+			data: `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom</title>
+  <entry>
+    <id>1</id>
+    <title>Entry</title>
+    <summary>Plain summary</summary>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Inline <em>XHTML</em></p></div></content>
+  </entry>
+</feed>`,
+			wantDescription: "Plain summary",
+			wantContent:     "<p>Inline <em>XHTML</em></p>",
+			wantType:        contentHTML,
+		},
+		{
+			name: "Atom HTML summary",
+			data: `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom</title>
+  <entry>
+    <id>1</id>
+    <title>Entry</title>
+    <summary type="html">&lt;p&gt;HTML summary&lt;/p&gt;</summary>
+  </entry>
+</feed>`,
+			wantDescription: "<p>HTML summary</p>",
+			wantContent:     "<p>HTML summary</p>",
+			wantType:        contentHTML,
+		},
+		{
+			name: "Atom text content",
+			data: `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom</title>
+  <entry>
+    <id>1</id>
+    <title>Entry</title>
+    <content>Plain &lt;text&gt;</content>
+  </entry>
+</feed>`,
+			wantDescription: "Plain <text>",
+			wantContent:     "Plain <text>",
+			wantType:        contentText,
+		},
+		{
+			name: "JSON Feed",
+			data: `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "JSON",
+  "items": [{"id": "1", "url": "https://example.com/1", "summary": "Summary", "content_text": "Text", "content_html": "<p>HTML <img src='pic.png'></p>"}]
+}`,
+			wantDescription: "Summary",
+			wantContent:     "<p>HTML <img src='pic.png'></p>",
+			wantType:        contentHTML,
+			wantThumbnail:   "https://example.com/pic.png",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed([]byte(tt.data), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			item := feed.Items[0]
+			if item.Description != tt.wantDescription {
+				t.Errorf("Description = %q, want %q", item.Description, tt.wantDescription)
+			}
+			if item.Content != tt.wantContent || item.ContentType != tt.wantType {
+				t.Errorf("Content = %q (%s), want %q (%s)", item.Content, item.ContentType, tt.wantContent, tt.wantType)
 			}
+			if item.Thumbnail != tt.wantThumbnail {
+				t.Errorf("Thumbnail = %q, want %q", item.Thumbnail, tt.wantThumbnail)
+			}
+		})
+	}
+}
 
-			if !tt.wantAtom && atomFeed != nil {
-				t.Error("Expected no Atom feed but got one")
+func TestParseFeedTags(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "RSS categories",
+			data: `<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>Blog</title>
+    <item>
+      <title>Post</title>
+      <category>Go</category>
+      <category domain="https://example.com/tags"> Open   Source </category>
+      <category>go</category>
+      <media:category scheme="urn:example">music/rock</media:category>
+      <dc:subject>Programming</dc:subject>
+    </item>
+  </channel>
+</rss>`,
+			want: []string{"Go", "Open Source", "Programming"},
+		},
+		{
+			name: "RDF subjects",
+			data: `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel><title>RDF</title></channel>
+  <item rdf:about="https://example.com/1"><title>Post</title><dc:subject>Science</dc:subject></item>
+</rdf:RDF>`,
+			want: []string{"Science"},
+		},
+		{
+			name: "Atom categories",
+			data: `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom</title>
+  <entry>
+    <id>1</id>
+    <title>Entry</title>
+    <category term="golang" label="Go"/>
+    <category term="release"/>
+    <category term=""/>
+  </entry>
+</feed>`,
+			want: []string{"Go", "release"},
+		},
+		{
+			name: "JSON Feed tags",
+			data: `{"version": "https://jsonfeed.org/version/1.1", "title": "JSON", "items": [{"id": "1", "title": "Post", "tags": ["news", "", "News", "tech"]}]}`,
+			want: []string{"news", "tech"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed([]byte(tt.data), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := feed.Items[0].Tags; !slices.Equal(got, tt.want) {
+				t.Errorf("Tags = %q, want %q", got, tt.want)
 			}
 		})
 	}
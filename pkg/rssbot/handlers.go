@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -17,8 +21,14 @@ func (b *Bot) registerHandlers() {
 	b.bot.RegisterHandler(bot.HandlerTypeMessageText, "/sub", bot.MatchTypePrefix, b.wrapHandler(b.handleSubscribe))
 	b.bot.RegisterHandler(bot.HandlerTypeMessageText, "/unsub", bot.MatchTypePrefix, b.wrapHandler(b.handleUnsubscribe))
 	b.bot.RegisterHandler(bot.HandlerTypeMessageText, "/feeds", bot.MatchTypeExact, b.wrapHandler(b.handleListFeeds))
+	b.bot.RegisterHandler(bot.HandlerTypeMessageText, "/timezone", bot.MatchTypePrefix, b.wrapHandler(b.handleTimezone))
+	b.bot.RegisterHandler(bot.HandlerTypeMessageText, "/template", bot.MatchTypePrefix, b.wrapHandler(b.handleTemplate))
+	b.bot.RegisterHandler(bot.HandlerTypeCallbackQueryData, feedErrorCallbackPrefix, bot.MatchTypePrefix, b.wrapCallbackHandler(b.handleFeedErrorCallback))
 }
 
+// errorNoticeSnooze is how long the Snooze button silences failure warnings.
+const errorNoticeSnooze = 7 * 24 * time.Hour
+
 func (b *Bot) wrapHandler(handler func(context.Context, *bot.Bot, *models.Update)) func(context.Context, *bot.Bot, *models.Update) {
 	return func(ctx context.Context, tgbot *bot.Bot, update *models.Update) {
 		if update.Message == nil || update.Message.From == nil {
@@ -33,11 +43,41 @@ func (b *Bot) wrapHandler(handler func(context.Context, *bot.Bot, *models.Update
 			})
 			return
 		}
+		b.resumeChat(update.Message.Chat.ID)
 
 		handler(ctx, tgbot, update)
 	}
 }
 
+func (b *Bot) wrapCallbackHandler(handler func(context.Context, *bot.Bot, *models.Update)) func(context.Context, *bot.Bot, *models.Update) {
+	return func(ctx context.Context, tgbot *bot.Bot, update *models.Update) {
+		if update.CallbackQuery == nil {
+			return
+		}
+
+		chatID, ok := callbackChatID(update.CallbackQuery)
+		if !ok || !b.isChatAllowed(fmt.Sprintf("%d", chatID)) {
+			tgbot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: update.CallbackQuery.ID,
+			})
+			return
+		}
+		b.resumeChat(chatID)
+
+		handler(ctx, tgbot, update)
+	}
+}
+
+func callbackChatID(query *models.CallbackQuery) (int64, bool) {
+	switch {
+	case query.Message.Message != nil:
+		return query.Message.Message.Chat.ID, true
+	case query.Message.InaccessibleMessage != nil:
+		return query.Message.InaccessibleMessage.Chat.ID, true
+	}
+	return 0, false
+}
+
 func (b *Bot) handleStart(ctx context.Context, tgbot *bot.Bot, update *models.Update) {
 	text := "Welcome to RSS Bot! 🤖\n\n" +
 		"I can help you subscribe to RSS feeds and notify you when new posts are published.\n\n" +
@@ -55,7 +95,9 @@ func (b *Bot) handleHelp(ctx context.Context, tgbot *bot.Bot, update *models.Upd
 		"/help - Show this help message\n" +
 		"/sub <url> - Subscribe to an RSS feed\n" +
 		"/unsub <search> - Unsubscribe from a feed\n" +
-		"/feeds - List your subscribed feeds"
+		"/feeds - List this chat's feeds\n" +
+		"/timezone [zone] - Show or set the time zone for post dates\n" +
+		"/template [template] - Show or set how posts are formatted"
 
 	tgbot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
@@ -98,17 +140,17 @@ func (b *Bot) handleSubscribe(ctx context.Context, tgbot *bot.Bot, update *model
 	}
 
 	sub := &Subscription{
-		UserID:   update.Message.From.ID,
-		ChatID:   update.Message.Chat.ID,
-		FeedURL:  feedURL,
-		FeedInfo: *feedInfo,
+		ChatID:    update.Message.Chat.ID,
+		FeedURL:   feedURL,
+		CreatedBy: update.Message.From.ID,
+		FeedInfo:  *feedInfo,
 	}
 
 	if err := b.db.AddSubscription(sub); err != nil {
 		if strings.Contains(err.Error(), "already subscribed") {
 			tgbot.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: update.Message.Chat.ID,
-				Text:   "You are already subscribed to this feed.",
+				Text:   "This chat is already subscribed to this feed.",
 			})
 		} else {
 			tgbot.SendMessage(ctx, &bot.SendMessageParams{
@@ -119,6 +161,13 @@ func (b *Bot) handleSubscribe(ctx context.Context, tgbot *bot.Bot, update *model
 		return
 	}
 
+	// Check the feed on the next tick, even if it is scheduled for later
+	// for its other subscribers, so the subscription starts from what the
+	// feed holds now.
+	if err := b.retryFeedNow(feedURL); err != nil {
+		log.Printf("Failed to schedule first check of %s: %v", feedURL, err)
+	}
+
 	tgbot.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
 		Text:   fmt.Sprintf("✅ Subscribed to: %s", feedInfo.Title),
@@ -136,24 +185,16 @@ func (b *Bot) handleUnsubscribe(ctx context.Context, tgbot *bot.Bot, update *mod
 	}
 
 	search := strings.TrimSpace(parts[1])
-	subscriptions, err := b.db.GetUserSubscriptions(update.Message.From.ID)
+	subscriptions, err := b.db.GetChatSubscriptions(update.Message.Chat.ID)
 	if err != nil {
 		tgbot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "Failed to get your subscriptions.",
+			Text:   "Failed to get this chat's subscriptions.",
 		})
 		return
 	}
 
-	var matches []*Subscription
-	searchLower := strings.ToLower(search)
-	for _, sub := range subscriptions {
-		if strings.Contains(strings.ToLower(sub.FeedInfo.Title), searchLower) ||
-			strings.Contains(strings.ToLower(sub.FeedURL), searchLower) {
-			matches = append(matches, sub)
-		}
-	}
-
+	matches := matchSubscriptions(subscriptions, search)
 	if len(matches) == 0 {
 		tgbot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
@@ -163,7 +204,7 @@ func (b *Bot) handleUnsubscribe(ctx context.Context, tgbot *bot.Bot, update *mod
 	}
 
 	if len(matches) == 1 {
-		if err := b.db.RemoveSubscription(update.Message.From.ID, matches[0].FeedURL); err != nil {
+		if err := b.db.RemoveSubscription(update.Message.Chat.ID, matches[0].FeedURL); err != nil {
 			tgbot.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: update.Message.Chat.ID,
 				Text:   fmt.Sprintf("Failed to unsubscribe: %v", err),
@@ -184,19 +225,33 @@ func (b *Bot) handleUnsubscribe(ctx context.Context, tgbot *bot.Bot, update *mod
 	}
 	text += "\nPlease be more specific."
 
-	tgbot.SendMessage(ctx, &bot.SendMessageParams{
+	b.sendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
 		Text:   text,
 	})
 }
 
+// matchSubscriptions returns the subscriptions whose feed title or URL
+// contains search, ignoring case.
+func matchSubscriptions(subscriptions []*Subscription, search string) []*Subscription {
+	var matches []*Subscription
+	searchLower := strings.ToLower(search)
+	for _, sub := range subscriptions {
+		if strings.Contains(strings.ToLower(sub.FeedInfo.Title), searchLower) ||
+			strings.Contains(strings.ToLower(sub.FeedURL), searchLower) {
+			matches = append(matches, sub)
+		}
+	}
+	return matches
+}
+
 func (b *Bot) handleListFeeds(ctx context.Context, tgbot *bot.Bot, update *models.Update) {
-	subscriptions, err := b.db.GetUserSubscriptions(update.Message.From.ID)
+	subscriptions, err := b.db.GetChatSubscriptions(update.Message.Chat.ID)
 	if err != nil {
-		log.Printf("Error getting user subscriptions: %v", err)
+		log.Printf("Error getting subscriptions for chat %d: %v", update.Message.Chat.ID, err)
 		tgbot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "Failed to get your subscriptions.",
+			Text:   "Failed to get this chat's subscriptions.",
 		})
 		return
 	}
@@ -204,22 +259,236 @@ func (b *Bot) handleListFeeds(ctx context.Context, tgbot *bot.Bot, update *model
 	if len(subscriptions) == 0 {
 		tgbot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "You have no active subscriptions. Use /sub <url> to subscribe to a feed.",
+			Text:   "This chat has no subscriptions. Use /sub <url> to subscribe to a feed.",
 		})
 		return
 	}
 
-	text := "Your subscribed feeds:\n\n"
+	text := "Feeds this chat is subscribed to:\n\n"
 	for i, sub := range subscriptions {
-		title := sub.FeedInfo.Title
-		if len(title) > 50 {
-			title = title[:47] + "..."
+		title := truncateMessage(sub.FeedInfo.Title, 50, false)
+		if sub.Broken {
+			title += " ⚠️ broken"
 		}
 		text += fmt.Sprintf("%d. %s\n", i+1, title)
 	}
 
-	tgbot.SendMessage(ctx, &bot.SendMessageParams{
+	b.sendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
 		Text:   text,
 	})
-}
\ No newline at end of file
+}
+
+func (b *Bot) handleTimezone(ctx context.Context, tgbot *bot.Bot, update *models.Update) {
+	parts := strings.SplitN(update.Message.Text, " ", 2)
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		tgbot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text: fmt.Sprintf("Post dates are shown in %s. Usage: /timezone <zone>, e.g. /timezone Europe/Berlin",
+				b.chatLocation(update.Message.Chat.ID)),
+		})
+		return
+	}
+
+	name := strings.TrimSpace(parts[1])
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		tgbot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Unknown time zone. Please use an IANA name such as Europe/Berlin or America/New_York.",
+		})
+		return
+	}
+
+	if err := b.db.SetChatTimezone(update.Message.Chat.ID, loc.String()); err != nil {
+		tgbot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Failed to set time zone: %v", err),
+		})
+		return
+	}
+
+	tgbot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("✅ Post dates will be shown in %s.", loc),
+	})
+}
+
+const templateUsage = "Usage:\n" +
+	"/template <template> - Format this chat's posts with a template\n" +
+	"/template feed <search>, then the template on the next line - Format one feed's posts\n" +
+	"/template reset, /template feed <search> reset - Go back to the default\n\n" +
+	"Templates use Go text/template syntax and Telegram HTML (<b>, <i>, <u>, <s>, <a>, <code>, <pre>, <blockquote>). " +
+	"Fields: {{.Title}}, {{.Link}}, {{.Author}}, {{.Date}}, {{.Published}}, {{.Excerpt}}, {{.Tags}}, " +
+	"{{.Feed.Title}}, {{.Feed.Link}}, {{.Feed.Description}} and {{.Feed.URL}}. " +
+	"Functions: join, hashtag and truncate, e.g. {{range .Tags}}{{hashtag .}} {{end}}"
+
+func (b *Bot) handleTemplate(ctx context.Context, tgbot *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	args := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/template"))
+
+	// "/template feed <search>" selects a subscription; its template
+	// follows on the next line, or "reset" on the same one.
+	var sub *Subscription
+	if rest, ok := strings.CutPrefix(args, "feed "); ok {
+		search, source, _ := strings.Cut(rest, "\n")
+		if s, ok := strings.CutSuffix(strings.TrimSpace(search), " reset"); ok && strings.TrimSpace(source) == "" {
+			search, source = s, "reset"
+		}
+		subscriptions, err := b.db.GetChatSubscriptions(update.Message.Chat.ID)
+		if err != nil {
+			tgbot.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   "Failed to get this chat's subscriptions.",
+			})
+			return
+		}
+		matches := matchSubscriptions(subscriptions, strings.TrimSpace(search))
+		if len(matches) != 1 {
+			text := "No matching feeds found."
+			if len(matches) > 1 {
+				text = "Multiple feeds match your search. Please be more specific."
+			}
+			tgbot.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   text,
+			})
+			return
+		}
+		sub, args = matches[0], strings.TrimSpace(source)
+	}
+
+	switch args {
+	case "":
+		var current string
+		switch {
+		case sub != nil && sub.Template != "":
+			current = fmt.Sprintf("%s uses this template:\n\n%s", sub.FeedInfo.Title, sub.Template)
+		case sub != nil:
+			current = fmt.Sprintf("%s uses the chat's template.", sub.FeedInfo.Title)
+		default:
+			if settings, ok := b.db.GetChatSettings(chatID); ok && settings.Template != "" {
+				current = "This chat uses this template:\n\n" + settings.Template
+			} else {
+				current = "This chat uses the default template:\n\n" + defaultTemplate
+			}
+		}
+		b.sendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   current + "\n\n" + templateUsage,
+		})
+		return
+	case "reset":
+		args = ""
+	}
+
+	var tmpl *template.Template
+	if args != "" {
+		var err error
+		if tmpl, err = parseMessageTemplate(args); err != nil {
+			tgbot.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   fmt.Sprintf("Invalid template: %v", err),
+			})
+			return
+		}
+	}
+
+	var err error
+	if sub != nil {
+		err = b.db.SetSubscriptionTemplate(chatID, sub.FeedURL, args)
+	} else {
+		err = b.db.SetChatTemplate(chatID, args)
+	}
+	if err != nil {
+		tgbot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("Failed to save template: %v", err),
+		})
+		return
+	}
+
+	if tmpl == nil {
+		tgbot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "✅ Template reset.",
+		})
+		return
+	}
+
+	preview, _ := executeMessageTemplate(tmpl, sampleTemplateData)
+	b.sendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    chatID,
+		Text:      "✅ Template saved. Preview:\n\n" + preview,
+		ParseMode: models.ParseModeHTML,
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	})
+}
+
+func (b *Bot) handleFeedErrorCallback(ctx context.Context, tgbot *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	chatID, _ := callbackChatID(query)
+	answer := func(text string) {
+		tgbot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            text,
+		})
+	}
+
+	parts := strings.Split(strings.TrimPrefix(query.Data, feedErrorCallbackPrefix), ":")
+	if len(parts) != 3 {
+		answer("Unknown action.")
+		return
+	}
+	// The subscription is looked up in the chat the button is in. The ID
+	// in the data is only checked for form, as buttons sent before
+	// subscriptions belonged to chats carry their creator's ID.
+	action, key := parts[0], parts[2]
+	if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+		answer("Unknown action.")
+		return
+	}
+
+	subscriptions, err := b.db.GetChatSubscriptions(chatID)
+	if err != nil {
+		answer("Failed to get subscriptions.")
+		return
+	}
+	idx := slices.IndexFunc(subscriptions, func(sub *Subscription) bool {
+		return feedKey(sub.FeedURL) == key
+	})
+	if idx < 0 {
+		answer("This feed is no longer subscribed.")
+		return
+	}
+	sub := subscriptions[idx]
+
+	switch action {
+	case "retry":
+		if err := b.retryFeedNow(sub.FeedURL); err != nil {
+			answer(fmt.Sprintf("Failed to schedule retry: %v", err))
+			return
+		}
+		answer("The feed will be retried within a minute.")
+	case "unsub":
+		if err := b.db.RemoveSubscription(sub.ChatID, sub.FeedURL); err != nil {
+			answer(fmt.Sprintf("Failed to unsubscribe: %v", err))
+			return
+		}
+		answer("Unsubscribed.")
+		tgbot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("✅ Unsubscribed from: %s", sub.FeedInfo.Title),
+		})
+	case "snooze":
+		if err := b.db.SnoozeErrorNotices(sub.ChatID, sub.FeedURL, time.Now().Add(errorNoticeSnooze)); err != nil {
+			answer(fmt.Sprintf("Failed to snooze: %v", err))
+			return
+		}
+		answer("Warnings for this feed are snoozed for 7 days.")
+	default:
+		answer("Unknown action.")
+	}
+}
@@ -0,0 +1,86 @@
+package rssbot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestGroupSubscriptions(t *testing.T) {
+	const groupID, alice, bob = -100, 1, 2
+	fake := &fakeTelegram{}
+	b := newTestBot(t, fake)
+	if err := b.db.AddSubscription(&Subscription{
+		ChatID:    groupID,
+		FeedURL:   "https://example.com/feed.xml",
+		CreatedBy: alice,
+		FeedInfo:  FeedInfo{Title: "Example Blog"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// send runs handler for a message from a user in a chat and returns
+	// the bot's reply.
+	send := func(handler func(context.Context, *bot.Bot, *models.Update), chatID, userID int64, text string) string {
+		t.Helper()
+		fake.calls = nil
+		handler(context.Background(), b.bot, &models.Update{Message: &models.Message{
+			Chat: models.Chat{ID: chatID},
+			From: &models.User{ID: userID},
+			Text: text,
+		}})
+		if len(fake.calls) != 1 {
+			t.Fatalf("Expected 1 reply to %q, got %d", text, len(fake.calls))
+		}
+		return fake.calls[0].Params["text"]
+	}
+
+	if reply := send(b.handleListFeeds, groupID, bob, "/feeds"); !strings.Contains(reply, "Example Blog") {
+		t.Errorf("Expected every member to see the group's feeds, got %q", reply)
+	}
+	if reply := send(b.handleListFeeds, alice, alice, "/feeds"); strings.Contains(reply, "Example Blog") {
+		t.Errorf("Expected the group's feeds to stay out of a private chat, got %q", reply)
+	}
+	if reply := send(b.handleUnsubscribe, alice, alice, "/unsub example"); !strings.Contains(reply, "No matching feeds") {
+		t.Errorf("Expected nothing to unsubscribe in a private chat, got %q", reply)
+	}
+	if reply := send(b.handleUnsubscribe, groupID, bob, "/unsub example"); !strings.Contains(reply, "Unsubscribed") {
+		t.Errorf("Expected another member to unsubscribe the group, got %q", reply)
+	}
+	if subs, _ := b.db.GetAllSubscriptions(); len(subs) != 0 {
+		t.Errorf("Expected no subscriptions left, got %+v", subs)
+	}
+}
+
+func TestTemplateFeedReset(t *testing.T) {
+	fake := &fakeTelegram{}
+	b := newTestBot(t, fake)
+	const feedURL = "https://example.com/feed.xml"
+	if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: feedURL, FeedInfo: FeedInfo{Title: "Example Blog"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"/template feed example reset", "/template feed example\nreset"} {
+		t.Run(text, func(t *testing.T) {
+			if err := b.db.SetSubscriptionTemplate(1, feedURL, "{{.Title}}"); err != nil {
+				t.Fatal(err)
+			}
+			fake.calls = nil
+			b.handleTemplate(context.Background(), b.bot, &models.Update{Message: &models.Message{
+				Chat: models.Chat{ID: 1},
+				From: &models.User{ID: 1},
+				Text: text,
+			}})
+
+			if len(fake.calls) != 1 || fake.calls[0].Params["text"] != "✅ Template reset." {
+				t.Errorf("Unexpected replies %+v", fake.calls)
+			}
+			if subs, _ := b.db.GetChatSubscriptions(1); subs[0].Template != "" {
+				t.Errorf("Expected the feed's template to be reset, got %q", subs[0].Template)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package rssbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// JSONFeed is a JSON Feed document (https://jsonfeed.org/version/1.1).
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Authors     []JSONAuthor   `json:"authors"`
+	Author      *JSONAuthor    `json:"author"` // JSON Feed 1.0
+	Items       []JSONFeedItem `json:"items"`
+}
+
+type JSONAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes"`
+}
+
+type JSONAuthor struct {
+	Name string `json:"name"`
+}
+
+type JSONFeedItem struct {
+	// ID may be a number in feeds that don't follow the spec.
+	ID            json.RawMessage  `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	Summary       string           `json:"summary"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Image         string           `json:"image"`
+	Attachments   []JSONAttachment `json:"attachments"`
+	Tags          []string         `json:"tags"`
+	Authors       []JSONAuthor     `json:"authors"`
+	Author        *JSONAuthor      `json:"author"` // JSON Feed 1.0
+}
+
+// isJSONFeedData reports whether data looks like a JSON document rather than
+// XML.
+func isJSONFeedData(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n\ufeff")
+	return len(data) > 0 && data[0] == '{'
+}
+
+func parseJSONFeed(data []byte) (*JSONFeed, error) {
+	var feed JSONFeed
+	if err := json.Unmarshal(bytes.TrimPrefix(data, []byte("\ufeff")), &feed); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(feed.Version, "https://jsonfeed.org/version/") {
+		return nil, fmt.Errorf("not a JSON Feed")
+	}
+	return &feed, nil
+}
+
+func init() {
+	registerFeedFormat(feedFormat{
+		name: "json",
+		sniff: func(root xml.Name, contentType string, data []byte) bool {
+			return root.Local == "" && isJSONFeedData(data)
+		},
+		parse: func(data []byte) (*Feed, error) {
+			jsonFeed, err := parseJSONFeed(data)
+			if err != nil {
+				return nil, err
+			}
+			return jsonFeed.toFeed(), nil
+		},
+	})
+}
+
+func (f *JSONFeed) toFeed() *Feed {
+	feed := &Feed{
+		Info: FeedInfo{
+			Title:       f.Title,
+			Description: f.Description,
+			Link:        f.HomePageURL,
+		},
+		Items:   make([]FeedItem, 0, len(f.Items)),
+		SelfURL: f.FeedURL,
+	}
+	for _, item := range f.Items {
+		description := item.Summary
+		if description == "" {
+			description = item.ContentHTML
+		}
+		if description == "" {
+			description = item.ContentText
+		}
+		content, contentType := item.body()
+		thumbnail := strings.TrimSpace(item.Image)
+		if thumbnail == "" && contentType == contentHTML {
+			thumbnail = firstImage(content, item.URL)
+		}
+		feed.Items = append(feed.Items, FeedItem{
+			Title:       strings.TrimSpace(item.Title),
+			Link:        item.URL,
+			Author:      strings.TrimSpace(firstAuthor(item.Authors, item.Author, firstAuthor(f.Authors, f.Author, ""))),
+			GUID:        item.id(),
+			Description: description,
+			Content:     content,
+			ContentType: contentType,
+			Published:   firstDate(item.DatePublished, item.DateModified),
+			Media:       item.media(),
+			Thumbnail:   thumbnail,
+			Tags:        appendTags(nil, item.Tags...),
+		})
+	}
+	return feed
+}
+
+// body returns the item's HTML content, else its text content, else its
+// summary, which JSON Feed defines as plain text.
+func (item *JSONFeedItem) body() (string, string) {
+	switch {
+	case strings.TrimSpace(item.ContentHTML) != "":
+		return item.ContentHTML, contentHTML
+	case strings.TrimSpace(item.ContentText) != "":
+		return item.ContentText, contentText
+	case strings.TrimSpace(item.Summary) != "":
+		return item.Summary, contentText
+	}
+	return "", ""
+}
+
+func (item *JSONFeedItem) media() []Media {
+	var media []Media
+	for _, a := range item.Attachments {
+		media = appendMedia(media, Media{URL: a.URL, Type: a.MimeType, Length: max(a.SizeInBytes, 0)})
+	}
+	return media
+}
+
+func (item *JSONFeedItem) id() string {
+	var id string
+	if err := json.Unmarshal(item.ID, &id); err == nil {
+		return id
+	}
+	return strings.TrimSpace(string(item.ID))
+}
+
+func firstAuthor(authors []JSONAuthor, author *JSONAuthor, fallback string) string {
+	for _, a := range authors {
+		if a.Name != "" {
+			return a.Name
+		}
+	}
+	if author != nil && author.Name != "" {
+		return author.Name
+	}
+	return fallback
+}
@@ -0,0 +1,65 @@
+package rssbot
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+// utf8BOM is the UTF-8 byte order mark some publishers put before the XML
+// declaration.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// trimPrologue strips byte order marks and whitespace before the first
+// character of a document.
+func trimPrologue(data []byte) []byte {
+	for {
+		trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+		if len(trimmed) == len(data) {
+			return data
+		}
+		data = trimmed
+	}
+}
+
+// decodeXML unmarshals an XML document into a new T. Documents the strict
+// decoder rejects are retried with newLenientXMLDecoder after
+// stripControlChars; the strict decoder's error is returned if that fails
+// too.
+func decodeXML[T any](data []byte) (*T, error) {
+	var v T
+	err := newXMLDecoder(data).Decode(&v)
+	if err == nil {
+		return &v, nil
+	}
+
+	var lenient T
+	if newLenientXMLDecoder(stripControlChars(data)).Decode(&lenient) != nil {
+		return nil, err
+	}
+	return &lenient, nil
+}
+
+// newLenientXMLDecoder returns a decoder that accepts the mistakes commonly
+// found in real-world feeds: unescaped ampersands, HTML entities such as
+// &nbsp; that XML doesn't define, unclosed HTML void elements like <br> and
+// missing end tags.
+func newLenientXMLDecoder(data []byte) *xml.Decoder {
+	d := newXMLDecoder(data)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	return d
+}
+
+// stripControlChars removes the C0 control characters XML 1.0 forbids. Only
+// ASCII bytes are removed, so the result is still valid in any
+// ASCII-compatible encoding.
+func stripControlChars(data []byte) []byte {
+	clean := make([]byte, 0, len(data))
+	for _, c := range data {
+		if c >= 0x20 || c == '\t' || c == '\n' || c == '\r' {
+			clean = append(clean, c)
+		}
+	}
+	return clean
+}
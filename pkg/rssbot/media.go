@@ -0,0 +1,165 @@
+package rssbot
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Media is a file attached to a feed item, such as a podcast episode or a
+// webcomic strip.
+type Media struct {
+	URL string
+	// Type is the MIME type, e.g. "audio/mpeg", and Medium the kind given
+	// by media:content's medium attribute. Either may be empty.
+	Type   string
+	Medium string
+	// Length is the size in bytes, or 0 if unknown.
+	Length int64
+}
+
+const (
+	mediaAudio = "audio"
+	mediaImage = "image"
+	mediaVideo = "video"
+)
+
+// mediaExtensions guesses the kind of media whose type isn't given from the
+// URL's file extension.
+var mediaExtensions = map[string]string{
+	".mp3":  mediaAudio,
+	".m4a":  mediaAudio,
+	".aac":  mediaAudio,
+	".ogg":  mediaAudio,
+	".oga":  mediaAudio,
+	".opus": mediaAudio,
+	".wav":  mediaAudio,
+	".flac": mediaAudio,
+	".jpg":  mediaImage,
+	".jpeg": mediaImage,
+	".png":  mediaImage,
+	".gif":  mediaImage,
+	".webp": mediaImage,
+	".mp4":  mediaVideo,
+	".m4v":  mediaVideo,
+	".mov":  mediaVideo,
+	".webm": mediaVideo,
+}
+
+// Kind returns mediaAudio, mediaImage or mediaVideo, or "" for other files.
+func (m Media) Kind() string {
+	switch medium := strings.ToLower(m.Medium); medium {
+	case mediaAudio, mediaImage, mediaVideo:
+		return medium
+	}
+	mainType, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(m.Type)), "/")
+	switch mainType {
+	case mediaAudio, mediaImage, mediaVideo:
+		return mainType
+	}
+	if u, err := url.Parse(m.URL); err == nil {
+		return mediaExtensions[strings.ToLower(path.Ext(u.Path))]
+	}
+	return ""
+}
+
+// primaryMedia picks the attachment to deliver with an item: its first
+// audio file, else its first image, else its first video.
+func primaryMedia(item FeedItem) (Media, bool) {
+	for _, kind := range []string{mediaAudio, mediaImage, mediaVideo} {
+		for _, m := range item.Media {
+			if m.Kind() == kind {
+				return m, true
+			}
+		}
+	}
+	return Media{}, false
+}
+
+// appendMedia adds m to media unless it has no URL or its URL is already
+// listed; feeds often repeat an enclosure as media:content.
+func appendMedia(media []Media, m Media) []Media {
+	m.URL = strings.TrimSpace(m.URL)
+	if m.URL == "" {
+		return media
+	}
+	for _, existing := range media {
+		if existing.URL == m.URL {
+			return media
+		}
+	}
+	return append(media, m)
+}
+
+// RSSEnclosure is an RSS <enclosure>.
+type RSSEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// MediaContent is a Media RSS <media:content>, used by RSS and Atom feeds.
+type MediaContent struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	FileSize string `xml:"fileSize,attr"`
+}
+
+// MediaThumbnail is a Media RSS <media:thumbnail>.
+type MediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+// MediaGroup is a Media RSS <media:group>, which wraps alternative versions
+// of the same media.
+type MediaGroup struct {
+	Contents   []MediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails []MediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// MediaElements are the Media RSS elements an RSS item or Atom entry may
+// carry.
+type MediaElements struct {
+	MediaContents   []MediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaThumbnails []MediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	MediaGroups     []MediaGroup     `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+// media returns the files listed in media:content elements.
+func (e *MediaElements) media() []Media {
+	var media []Media
+	contents := e.MediaContents
+	for _, group := range e.MediaGroups {
+		contents = append(contents, group.Contents...)
+	}
+	for _, c := range contents {
+		media = appendMedia(media, Media{URL: c.URL, Type: c.Type, Medium: c.Medium, Length: parseLength(c.FileSize)})
+	}
+	return media
+}
+
+// thumbnail returns the URL of the first media:thumbnail, or "".
+func (e *MediaElements) thumbnail() string {
+	thumbnails := e.MediaThumbnails
+	for _, group := range e.MediaGroups {
+		thumbnails = append(thumbnails, group.Thumbnails...)
+	}
+	for _, t := range thumbnails {
+		if u := strings.TrimSpace(t.URL); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// parseLength parses an enclosure length attribute, returning 0 for
+// missing or bogus values.
+func parseLength(s string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,42 @@
+package rssbot
+
+import "testing"
+
+func TestMediaKind(t *testing.T) {
+	tests := []struct {
+		media Media
+		want  string
+	}{
+		{Media{URL: "https://example.com/a", Type: "audio/mpeg"}, mediaAudio},
+		{Media{URL: "https://example.com/a", Type: "Image/JPEG"}, mediaImage},
+		{Media{URL: "https://example.com/a", Type: "video/mp4"}, mediaVideo},
+		{Media{URL: "https://example.com/a", Medium: "image"}, mediaImage},
+		{Media{URL: "https://example.com/a.jpg", Medium: "video"}, mediaVideo},
+		{Media{URL: "https://example.com/ep.MP3?source=rss"}, mediaAudio},
+		{Media{URL: "https://example.com/strip.webp"}, mediaImage},
+		{Media{URL: "https://example.com/doc.pdf", Type: "application/pdf"}, ""},
+		{Media{URL: "https://example.com/file"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.media.Kind(); got != tt.want {
+			t.Errorf("%+v.Kind() = %q, want %q", tt.media, got, tt.want)
+		}
+	}
+}
+
+func TestPrimaryMedia(t *testing.T) {
+	item := FeedItem{Media: []Media{
+		{URL: "https://example.com/doc.pdf"},
+		{URL: "https://example.com/clip.mp4"},
+		{URL: "https://example.com/a.png"},
+		{URL: "https://example.com/b.png"},
+	}}
+	if m, ok := primaryMedia(item); !ok || m.URL != "https://example.com/a.png" {
+		t.Errorf("primaryMedia() = %+v, %v, want the first image", m, ok)
+	}
+
+	if _, ok := primaryMedia(FeedItem{Media: []Media{{URL: "https://example.com/doc.pdf"}}}); ok {
+		t.Error("Expected no primary media for a document")
+	}
+}
@@ -1,11 +1,16 @@
 package rssbot
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"html"
 	"log"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -17,27 +22,84 @@ type FeedItem struct {
 	Author      string
 	GUID        string
 	Description string
+	// Content is the item's fullest body, such as content:encoded or Atom
+	// content, falling back to the summary. ContentType says whether it is
+	// contentHTML or contentText.
+	Content     string
+	ContentType string
+	// Published is when the item was published, or the zero Time if the
+	// feed doesn't say.
+	Published time.Time
+	// Media are the item's attachments in feed order, and Thumbnail the
+	// URL of an image representing it, such as a podcast episode's cover.
+	Media     []Media
+	Thumbnail string
+	// Tags are the item's categories or tags, without duplicates.
+	Tags []string
 }
 
-func (b *Bot) extractRSSItems(feed *RSSFeed) []FeedItem {
+// maxNewItemsPerCheck caps how many items are delivered for a single feed in
+// one check, so a feed that reshuffles its GUIDs can't flood a chat.
+const maxNewItemsPerCheck = 20
+
+// itemID returns the identity used to recognise an item across checks: its
+// GUID, or a hash of its link and title for feeds that don't provide one.
+func itemID(item FeedItem) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	sum := sha256.Sum256([]byte(item.Link + "\n" + item.Title))
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
+
+// newItemsSince returns the items whose identity is not in seen, oldest
+// first. items is expected newest first, as feeds list them; at most limit
+// of the newest unseen items are returned.
+func newItemsSince(items []FeedItem, seen map[string]bool, limit int) []FeedItem {
+	var unseen []FeedItem
+	for _, item := range items {
+		if len(unseen) == limit {
+			break
+		}
+		if !seen[itemID(item)] {
+			unseen = append(unseen, item)
+		}
+	}
+	slices.Reverse(unseen)
+	return unseen
+}
+
+func extractRSSItems(feed *RSSFeed) []FeedItem {
 	items := make([]FeedItem, 0, len(feed.Channel.Items))
 	for _, item := range feed.Channel.Items {
 		author := strings.TrimSpace(item.Author)
 		if author == "" {
 			author = strings.TrimSpace(item.Creator)
 		}
+		// RSS descriptions are HTML by convention.
+		content := cmp.Or(strings.TrimSpace(item.Content), strings.TrimSpace(item.Description))
+		thumbnail := cmp.Or(item.thumbnail(), strings.TrimSpace(item.ITunesImage.Href))
+		if thumbnail == "" {
+			thumbnail = firstImage(content, item.Link)
+		}
 		items = append(items, FeedItem{
 			Title:       strings.TrimSpace(item.Title),
 			Link:        item.Link,
 			Author:      author,
 			GUID:        item.GUID,
 			Description: item.Description,
+			Content:     content,
+			ContentType: contentHTML,
+			Published:   firstDate(item.PubDate, item.Date),
+			Media:       rssItemMedia(&item),
+			Thumbnail:   thumbnail,
+			Tags:        rssItemTags(&item),
 		})
 	}
 	return items
 }
 
-func (b *Bot) extractAtomItems(feed *AtomFeed) []FeedItem {
+func extractAtomItems(feed *AtomFeed) []FeedItem {
 	items := make([]FeedItem, 0, len(feed.Entries))
 	for _, entry := range feed.Entries {
 		link := ""
@@ -47,40 +109,105 @@ func (b *Bot) extractAtomItems(feed *AtomFeed) []FeedItem {
 				break
 			}
 		}
-		content := entry.Summary
+		summary, summaryType := entry.Summary.body()
+		content, contentType := entry.Content.body()
+		description := summary
+		if description == "" {
+			description = content
+		}
 		if content == "" {
-			content = entry.Content
+			content, contentType = summary, summaryType
+		}
+		thumbnail := entry.thumbnail()
+		if thumbnail == "" && contentType == contentHTML {
+			thumbnail = firstImage(content, link)
 		}
 		items = append(items, FeedItem{
 			Title:       strings.TrimSpace(entry.Title),
 			Link:        link,
 			Author:      strings.TrimSpace(entry.Author.Name),
 			GUID:        entry.ID,
-			Description: content,
+			Description: description,
+			Content:     content,
+			ContentType: contentType,
+			Published:   firstDate(entry.Published, entry.Updated),
+			Media:       atomEntryMedia(&entry),
+			Thumbnail:   thumbnail,
+			Tags:        atomEntryTags(&entry),
 		})
 	}
 	return items
 }
 
-func (b *Bot) sendFeedUpdate(ctx context.Context, sub *Subscription, item FeedItem) error {
-	title := strings.TrimSpace(html.UnescapeString(item.Title))
-	feedTitle := html.UnescapeString(sub.FeedInfo.Title)
+// rssItemMedia returns the item's enclosures followed by its media:content.
+func rssItemMedia(item *RSSItem) []Media {
+	var media []Media
+	for _, e := range item.Enclosures {
+		media = appendMedia(media, Media{URL: e.URL, Type: e.Type, Length: parseLength(e.Length)})
+	}
+	for _, m := range item.media() {
+		media = appendMedia(media, m)
+	}
+	return media
+}
 
-	var messageText strings.Builder
-	messageText.WriteString(fmt.Sprintf("<b><u>%s</u></b>\n\n", escapeHTML(title)))
-	messageText.WriteString("via ")
+// rssItemTags returns an item's categories and Dublin Core subjects.
+func rssItemTags(item *RSSItem) []string {
+	var tags []string
+	for _, c := range item.Categories {
+		if c.XMLName.Space == "" {
+			tags = appendTags(tags, c.Value)
+		}
+	}
+	return appendTags(tags, item.Subjects...)
+}
 
-	// Link the feed title to the post URL
-	messageText.WriteString(fmt.Sprintf("<a href=\"%s\">%s</a>", item.Link, escapeHTML(feedTitle)))
+// atomEntryTags returns the labels of an entry's categories, falling back
+// to their terms.
+func atomEntryTags(entry *AtomEntry) []string {
+	var tags []string
+	for _, c := range entry.Categories {
+		tags = appendTags(tags, cmp.Or(strings.TrimSpace(c.Label), c.Term))
+	}
+	return tags
+}
 
-	if item.Author != "" {
-		author := strings.TrimSpace(item.Author)
-		messageText.WriteString(fmt.Sprintf(" (author: %s)", escapeHTML(author)))
+// atomEntryMedia returns the entry's rel="enclosure" links followed by its
+// media:content.
+func atomEntryMedia(entry *AtomEntry) []Media {
+	var media []Media
+	for _, l := range entry.Link {
+		if l.Rel == "enclosure" {
+			media = appendMedia(media, Media{URL: l.Href, Type: l.Type, Length: parseLength(l.Length)})
+		}
+	}
+	for _, m := range entry.media() {
+		media = appendMedia(media, m)
+	}
+	return media
+}
+
+func (b *Bot) sendFeedUpdate(ctx context.Context, sub *Subscription, item FeedItem) error {
+	text := b.renderItem(sub, item)
+
+	if media, ok := primaryMedia(item); ok {
+		caption := truncateMessage(text, maxCaptionLength, true)
+		if b.sendMedia(ctx, sub, item, media, caption) {
+			return nil
+		}
+		text += "\n" + mediaLink(media)
+	} else if item.Thumbnail != "" {
+		// Posts whose only picture is a thumbnail or an image in their
+		// body, as is usual for blogs, are sent as that photo too.
+		caption := truncateMessage(text, maxCaptionLength, true)
+		if b.sendMedia(ctx, sub, item, Media{URL: item.Thumbnail, Medium: mediaImage}, caption) {
+			return nil
+		}
 	}
 
-	_, err := b.bot.SendMessage(ctx, &bot.SendMessageParams{
+	err := b.sendMessage(ctx, &bot.SendMessageParams{
 		ChatID:    sub.ChatID,
-		Text:      messageText.String(),
+		Text:      text,
 		ParseMode: models.ParseModeHTML,
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			URL: &item.Link,
@@ -94,6 +221,208 @@ func (b *Bot) sendFeedUpdate(ctx context.Context, sub *Subscription, item FeedIt
 	return err
 }
 
+// itemExcerpt returns the item's body as Telegram HTML, cut to limit
+// characters, or "" if limit isn't positive.
+func itemExcerpt(item FeedItem, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	body := item.Content
+	if item.ContentType == contentText {
+		body = strings.ReplaceAll(escapeHTML(body), "\n", "<br>")
+	}
+	return sanitizeHTML(body, item.Link, limit)
+}
+
+// sendMessage sends params as one message, or as several if its text is too
+// long for one. The link preview is shown for the first message only and the
+// reply markup attached to the last.
+func (b *Bot) sendMessage(ctx context.Context, params *bot.SendMessageParams) error {
+	chunks := splitMessage(params.Text, maxMessageLength, params.ParseMode == models.ParseModeHTML)
+	for i, chunk := range chunks {
+		p := *params
+		p.Text = chunk
+		if i > 0 {
+			p.LinkPreviewOptions = &models.LinkPreviewOptions{IsDisabled: bot.True()}
+		}
+		if i < len(chunks)-1 {
+			p.ReplyMarkup = nil
+		}
+		if _, err := b.bot.SendMessage(ctx, &p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Telegram only fetches files up to these sizes from a URL.
+const (
+	maxPhotoURLSize = 5 << 20
+	maxFileURLSize  = 20 << 20
+)
+
+// sendMedia delivers audio with sendAudio and images with sendPhoto, with
+// caption as the caption. It reports whether the media was sent; if not,
+// e.g. because the file is too large or Telegram couldn't fetch it, the
+// caller falls back to a text message linking to it.
+func (b *Bot) sendMedia(ctx context.Context, sub *Subscription, item FeedItem, media Media, caption string) bool {
+	var err error
+	switch media.Kind() {
+	case mediaAudio:
+		if media.Length > maxFileURLSize {
+			return false
+		}
+		_, err = b.bot.SendAudio(ctx, &bot.SendAudioParams{
+			ChatID:    sub.ChatID,
+			Audio:     &models.InputFileString{Data: media.URL},
+			Caption:   caption,
+			ParseMode: models.ParseModeHTML,
+			Title:     strings.TrimSpace(html.UnescapeString(item.Title)),
+			Performer: html.UnescapeString(sub.FeedInfo.Title),
+		})
+	case mediaImage:
+		if media.Length > maxPhotoURLSize {
+			return false
+		}
+		_, err = b.bot.SendPhoto(ctx, &bot.SendPhotoParams{
+			ChatID:    sub.ChatID,
+			Photo:     &models.InputFileString{Data: media.URL},
+			Caption:   caption,
+			ParseMode: models.ParseModeHTML,
+		})
+	default:
+		return false
+	}
+
+	if err != nil {
+		log.Printf("Failed to send %s to chat %d, sending a link instead: %v", media.Kind(), sub.ChatID, err)
+		return false
+	}
+	return true
+}
+
+// mediaLink returns an HTML link to media for messages that don't carry the
+// file itself.
+func mediaLink(media Media) string {
+	label := "📎 Attachment"
+	switch media.Kind() {
+	case mediaAudio:
+		label = "🎧 Listen"
+	case mediaImage:
+		label = "🖼 View image"
+	case mediaVideo:
+		label = "🎬 Watch video"
+	}
+	return fmt.Sprintf("<a href=\"%s\">%s</a>", escapeHTML(media.URL), label)
+}
+
+// chatLocation returns the time zone chosen for the chat with /timezone, or
+// UTC.
+func (b *Bot) chatLocation(chatID int64) *time.Location {
+	settings, ok := b.db.GetChatSettings(chatID)
+	if !ok || settings.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		log.Printf("Invalid timezone %q for chat %d: %v", settings.Timezone, chatID, err)
+		return time.UTC
+	}
+	return loc
+}
+
 func escapeHTML(s string) string {
 	return html.EscapeString(s)
-}
\ No newline at end of file
+}
+
+func (b *Bot) sendFeedBroken(ctx context.Context, sub *Subscription, feedErr *FeedError) {
+	text := fmt.Sprintf("⚠️ The feed %s has failed %d checks in a row and has been marked as broken. "+
+		"It will still be retried occasionally.\n\nLast error: %s",
+		sub.FeedInfo.Title, feedErr.ErrorCount, feedErr.LastError)
+
+	err := b.sendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      sub.ChatID,
+		Text:        text,
+		ReplyMarkup: feedErrorKeyboard(sub),
+	})
+	if err != nil {
+		log.Printf("Failed to send message to chat %d: %v", sub.ChatID, err)
+	}
+}
+
+// sendChatUnreachable tells userID that the chat they subscribed to subs
+// can't be delivered to, and why.
+func (b *Bot) sendChatUnreachable(ctx context.Context, userID int64, subs []*Subscription, reason error) {
+	titles := make([]string, len(subs))
+	for i, sub := range subs {
+		titles[i] = sub.FeedInfo.Title
+	}
+	text := fmt.Sprintf("⚠️ Telegram no longer lets me post to the chat %d, which you subscribed to %s.\n\n"+
+		"Its subscriptions are paused until someone in it sends me a command again, e.g. after adding me back.\n\n"+
+		"Last error: %v",
+		subs[0].ChatID, strings.Join(titles, ", "), reason)
+
+	err := b.sendMessage(ctx, &bot.SendMessageParams{
+		ChatID: userID,
+		Text:   text,
+	})
+	if err != nil {
+		log.Printf("Failed to send message to chat %d: %v", userID, err)
+	}
+}
+
+func (b *Bot) sendFailureNotice(ctx context.Context, sub *Subscription, feedErr *FeedError) error {
+	since := feedErr.FirstErrorAt
+	if first, err := time.Parse(time.RFC3339, feedErr.FirstErrorAt); err == nil {
+		since = first.Format("January 2, 2006")
+	}
+	text := fmt.Sprintf("⚠️ The feed %s has not been working since %s.\n\nLast error: %s",
+		sub.FeedInfo.Title, since, feedErr.LastError)
+
+	err := b.sendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      sub.ChatID,
+		Text:        text,
+		ReplyMarkup: feedErrorKeyboard(sub),
+	})
+	if err != nil {
+		log.Printf("Failed to send message to chat %d: %v", sub.ChatID, err)
+	}
+	return err
+}
+
+func (b *Bot) sendFeedMoved(ctx context.Context, sub *Subscription, oldURL string) {
+	text := fmt.Sprintf("ℹ️ The feed %s has moved.\n\nOld address: %s\nNew address: %s\n\nYour subscription has been updated.",
+		sub.FeedInfo.Title, oldURL, sub.FeedURL)
+
+	err := b.sendMessage(ctx, &bot.SendMessageParams{
+		ChatID: sub.ChatID,
+		Text:   text,
+	})
+	if err != nil {
+		log.Printf("Failed to send message to chat %d: %v", sub.ChatID, err)
+	}
+}
+
+// feedErrorCallbackPrefix starts the callback data of the buttons attached to
+// feed error messages: "feederr:<action>:<chat id>:<feed key>".
+const feedErrorCallbackPrefix = "feederr:"
+
+// feedKey returns a short identifier for feedURL that fits in Telegram's
+// 64-byte callback data.
+func feedKey(feedURL string) string {
+	sum := sha256.Sum256([]byte(feedURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+func feedErrorKeyboard(sub *Subscription) *models.InlineKeyboardMarkup {
+	data := func(action string) string {
+		return fmt.Sprintf("%s%s:%d:%s", feedErrorCallbackPrefix, action, sub.ChatID, feedKey(sub.FeedURL))
+	}
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: "🔄 Retry now", CallbackData: data("retry")},
+			{Text: "🗑 Unsubscribe", CallbackData: data("unsub")},
+			{Text: "🔕 Snooze", CallbackData: data("snooze")},
+		}},
+	}
+}
@@ -0,0 +1,244 @@
+package rssbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// telegramCall is a Bot API request received by fakeTelegram.
+type telegramCall struct {
+	Method string
+	Params map[string]string
+}
+
+// fakeTelegram is a Bot API server that records every call and fails the
+// methods listed in fail. If reject is set, calls for which it returns an
+// error response, such as `{"ok": false, ...}`, get that instead.
+type fakeTelegram struct {
+	mu     sync.Mutex
+	calls  []telegramCall
+	fail   map[string]bool
+	reject func(call telegramCall) string
+}
+
+func newTestBot(t *testing.T, fake *fakeTelegram) *Bot {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		r.ParseMultipartForm(1 << 20)
+		params := make(map[string]string)
+		if r.MultipartForm != nil {
+			for name, values := range r.MultipartForm.Value {
+				params[name] = values[0]
+			}
+		}
+
+		call := telegramCall{Method: method, Params: params}
+		fake.mu.Lock()
+		fake.calls = append(fake.calls, call)
+		fail := fake.fail[method]
+		fake.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if fake.reject != nil {
+			if response := fake.reject(call); response != "" {
+				w.Write([]byte(response))
+				return
+			}
+		}
+		if fail {
+			w.Write([]byte(`{"ok": false, "error_code": 400, "description": "Bad Request: failed to get HTTP URL content"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true, "result": {"message_id": 1, "date": 0, "chat": {"id": 1, "type": "private"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	tgbot, err := bot.New("test-token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-message-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+	tmpFile.Close()
+
+	db, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Bot{bot: tgbot, db: db, config: &Config{}}
+}
+
+func TestSendFeedUpdateMedia(t *testing.T) {
+	sub := &Subscription{ChatID: 1, FeedInfo: FeedInfo{Title: "Podcast"}}
+
+	tests := []struct {
+		name       string
+		media      []Media
+		thumbnail  string
+		fail       string
+		wantMethod string
+		wantParam  string
+		wantValue  string
+	}{
+		{
+			name:       "Audio",
+			media:      []Media{{URL: "https://example.com/ep1.mp3", Type: "audio/mpeg"}},
+			wantMethod: "sendAudio",
+			wantParam:  "audio",
+			wantValue:  "https://example.com/ep1.mp3",
+		},
+		{
+			name:       "Image",
+			media:      []Media{{URL: "https://example.com/comic.png"}},
+			wantMethod: "sendPhoto",
+			wantParam:  "photo",
+			wantValue:  "https://example.com/comic.png",
+		},
+		{
+			name:       "Audio preferred over image",
+			media:      []Media{{URL: "https://example.com/cover.jpg", Medium: "image"}, {URL: "https://example.com/ep1.m4a"}},
+			wantMethod: "sendAudio",
+			wantParam:  "audio",
+			wantValue:  "https://example.com/ep1.m4a",
+		},
+		{
+			name:       "Video as link",
+			media:      []Media{{URL: "https://example.com/clip.mp4", Type: "video/mp4"}},
+			wantMethod: "sendMessage",
+			wantParam:  "text",
+			wantValue:  `<a href="https://example.com/clip.mp4">🎬 Watch video</a>`,
+		},
+		{
+			name:       "Audio too large for URL upload",
+			media:      []Media{{URL: "https://example.com/ep1.mp3", Type: "audio/mpeg", Length: 80 << 20}},
+			wantMethod: "sendMessage",
+			wantParam:  "text",
+			wantValue:  `<a href="https://example.com/ep1.mp3">🎧 Listen</a>`,
+		},
+		{
+			name:       "Thumbnail",
+			thumbnail:  "https://example.com/hero.jpg",
+			wantMethod: "sendPhoto",
+			wantParam:  "photo",
+			wantValue:  "https://example.com/hero.jpg",
+		},
+		{
+			name:       "Audio preferred over thumbnail",
+			media:      []Media{{URL: "https://example.com/ep1.mp3", Type: "audio/mpeg"}},
+			thumbnail:  "https://example.com/cover.jpg",
+			wantMethod: "sendAudio",
+			wantParam:  "audio",
+			wantValue:  "https://example.com/ep1.mp3",
+		},
+		{
+			name:       "Thumbnail rejected by Telegram",
+			thumbnail:  "https://example.com/hero.jpg",
+			fail:       "sendPhoto",
+			wantMethod: "sendMessage",
+			wantParam:  "text",
+			wantValue:  "Episode 1",
+		},
+		{
+			name:       "Photo rejected by Telegram",
+			media:      []Media{{URL: "https://example.com/comic.png", Type: "image/png"}},
+			fail:       "sendPhoto",
+			wantMethod: "sendMessage",
+			wantParam:  "text",
+			wantValue:  `<a href="https://example.com/comic.png">🖼 View image</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTelegram{fail: map[string]bool{tt.fail: true}}
+			b := newTestBot(t, fake)
+
+			item := FeedItem{Title: "Episode 1", Link: "https://example.com/ep1", Media: tt.media, Thumbnail: tt.thumbnail}
+			if err := b.sendFeedUpdate(t.Context(), sub, item); err != nil {
+				t.Fatalf("sendFeedUpdate() error = %v", err)
+			}
+
+			last := fake.calls[len(fake.calls)-1]
+			if last.Method != tt.wantMethod {
+				t.Fatalf("Last call = %s, want %s", last.Method, tt.wantMethod)
+			}
+			if !strings.Contains(last.Params[tt.wantParam], tt.wantValue) {
+				t.Errorf("%s = %q, want it to contain %q", tt.wantParam, last.Params[tt.wantParam], tt.wantValue)
+			}
+			if tt.wantMethod != "sendMessage" && !strings.Contains(last.Params["caption"], "Episode 1") {
+				t.Errorf("Expected caption with the item title, got %q", last.Params["caption"])
+			}
+		})
+	}
+}
+
+func TestSendFeedUpdateExcerpt(t *testing.T) {
+	fake := &fakeTelegram{}
+	b := newTestBot(t, fake)
+	b.config.ExcerptLength = 100
+
+	sub := &Subscription{ChatID: 1, FeedInfo: FeedInfo{Title: "Blog"}}
+	item := FeedItem{
+		Title:       "Post",
+		Link:        "https://example.com/post",
+		Content:     `<div class="entry"><p>Read <strong>this</strong> <a href="/more">now</a>.</p><script>track()</script></div>`,
+		ContentType: contentHTML,
+	}
+	if err := b.sendFeedUpdate(t.Context(), sub, item); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<b><u>Post</u></b>\n\nRead <b>this</b> <a href=\"https://example.com/more\">now</a>.\n\nvia "
+	if text := fake.calls[0].Params["text"]; !strings.HasPrefix(text, want) {
+		t.Errorf("text = %q, want prefix %q", text, want)
+	}
+}
+
+func TestSendMessageSplit(t *testing.T) {
+	fake := &fakeTelegram{}
+	b := newTestBot(t, fake)
+
+	paragraph := "<b>" + strings.Repeat("word ", 300) + "</b>"
+	err := b.sendMessage(t.Context(), &bot.SendMessageParams{
+		ChatID:    1,
+		Text:      strings.Join([]string{paragraph, paragraph, paragraph, paragraph}, "\n\n"),
+		ParseMode: models.ParseModeHTML,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: "OK", CallbackData: "ok"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.calls) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(fake.calls))
+	}
+	for i, call := range fake.calls {
+		text := call.Params["text"]
+		if !strings.HasPrefix(text, "<b>") || !strings.HasSuffix(text, "</b>") {
+			t.Errorf("Message %d isn't balanced: %.20q...%q", i, text, text[len(text)-20:])
+		}
+		last := i == len(fake.calls)-1
+		if _, ok := call.Params["reply_markup"]; ok != last {
+			t.Errorf("Message %d has keyboard = %v, want %v", i, ok, last)
+		}
+		if _, ok := call.Params["link_preview_options"]; ok != (i > 0) {
+			t.Errorf("Message %d has link preview options = %v, want %v", i, ok, i > 0)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package rssbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// schemaVersion is the version of the database document this code reads and
+// writes. Bump it together with registering the migration from the
+// previous version whenever the persisted shape of Database changes in a
+// way older code or older files would misread.
+const schemaVersion = 2
+
+// dbDocument is a database file decoded down to its top-level keys, the
+// form migrations work on. Migrations must not use the current Go types,
+// which describe only the newest version.
+type dbDocument map[string]json.RawMessage
+
+// migration upgrades a database document from version from to from+1.
+type migration struct {
+	from        int
+	description string
+	migrate     func(doc dbDocument) error
+}
+
+// migrations is the registry consulted by migrateDatabase, keyed by the
+// version each migration upgrades from.
+var migrations = make(map[int]migration)
+
+// registerMigration adds a migration to the registry. It is meant to be
+// called from init functions.
+func registerMigration(m migration) {
+	if _, exists := migrations[m.from]; exists {
+		panic(fmt.Sprintf("rssbot: duplicate migration from database version %d", m.from))
+	}
+	migrations[m.from] = m
+}
+
+func init() {
+	registerMigration(migration{
+		from:        0,
+		description: "add a schema version and drop last_item_guid, replaced by seen_items",
+		migrate:     migrateUnversioned,
+	})
+	registerMigration(migration{
+		from:        1,
+		description: "key subscriptions by chat instead of user, recording the user as created_by",
+		migrate:     migrateChatSubscriptions,
+	})
+}
+
+// errNewerSchema is returned for database files written by a newer version
+// of the bot, which must not be overwritten or replaced by a backup.
+var errNewerSchema = errors.New("database was written by a newer version of the bot")
+
+// migrateDatabase upgrades a database file to schemaVersion, returning the
+// upgraded file and the version it had.
+func migrateDatabase(data []byte) ([]byte, int, error) {
+	doc := make(dbDocument)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if raw, ok := doc["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid version: %w", err)
+		}
+	}
+	if version > schemaVersion {
+		return nil, version, fmt.Errorf("%w (schema version %d, this version supports up to %d)", errNewerSchema, version, schemaVersion)
+	}
+	if version == schemaVersion {
+		return data, version, nil
+	}
+
+	from := version
+	for ; version < schemaVersion; version++ {
+		m, ok := migrations[version]
+		if !ok {
+			return nil, from, fmt.Errorf("no migration from database version %d", version)
+		}
+		if err := m.migrate(doc); err != nil {
+			return nil, from, fmt.Errorf("migrating database from version %d: %w", version, err)
+		}
+		log.Printf("Migrated database from version %d to %d: %s", version, version+1, m.description)
+	}
+	doc["version"] = json.RawMessage(fmt.Sprint(version))
+
+	migrated, err := json.Marshal(doc)
+	return migrated, from, err
+}
+
+// migrateUnversioned upgrades the files written before the schema was
+// versioned. Their shape only grew over time, except that subscriptions
+// once remembered just the last item's GUID; those subscriptions start
+// over with no seen items, which makes their next check mark the feed's
+// current items as seen without sending them.
+func migrateUnversioned(doc dbDocument) error {
+	raw, ok := doc["subscriptions"]
+	if !ok {
+		return nil
+	}
+	var subscriptions map[string]map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &subscriptions); err != nil {
+		return err
+	}
+	for _, userSubs := range subscriptions {
+		for _, sub := range userSubs {
+			delete(sub, "last_item_guid")
+		}
+	}
+	return setDocumentKey(doc, "subscriptions", subscriptions)
+}
+
+// migrateChatSubscriptions re-keys subscriptions by chat instead of user and
+// renames user_id to created_by. Where several members of a chat subscribed
+// it to the same feed, the subscription of the member with the lowest user
+// ID is kept, and the others are folded into it by mergeSubscription.
+func migrateChatSubscriptions(doc dbDocument) error {
+	raw, ok := doc["subscriptions"]
+	if !ok {
+		return nil
+	}
+	var byUser map[string]map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &byUser); err != nil {
+		return err
+	}
+
+	userIDs := make([]int64, 0, len(byUser))
+	for userKey := range byUser {
+		userID, err := strconv.ParseInt(userKey, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid user ID %q: %w", userKey, err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	slices.Sort(userIDs)
+
+	byChat := make(map[string]map[string]map[string]json.RawMessage)
+	for _, userID := range userIDs {
+		for feedURL, sub := range byUser[strconv.FormatInt(userID, 10)] {
+			var chatID int64
+			if err := json.Unmarshal(sub["chat_id"], &chatID); err != nil {
+				return fmt.Errorf("invalid chat ID in user %d's subscription to %s: %w", userID, feedURL, err)
+			}
+			chatKey := strconv.FormatInt(chatID, 10)
+			if byChat[chatKey] == nil {
+				byChat[chatKey] = make(map[string]map[string]json.RawMessage)
+			}
+
+			if kept, exists := byChat[chatKey][feedURL]; exists {
+				if err := mergeSubscription(kept, sub); err != nil {
+					return fmt.Errorf("merging subscriptions to %s in chat %d: %w", feedURL, chatID, err)
+				}
+				log.Printf("Merged user %d's subscription to %s into chat %d's", userID, feedURL, chatID)
+				continue
+			}
+			sub["created_by"] = sub["user_id"]
+			delete(sub, "user_id")
+			byChat[chatKey][feedURL] = sub
+		}
+	}
+	return setDocumentKey(doc, "subscriptions", byChat)
+}
+
+// mergeSubscription folds other, a subscription to the same feed in the
+// same chat, into sub. sub takes on the items other had seen, so that none
+// are sent again, other's template if it has none, and the later of their
+// error notice times. It is broken if either is.
+func mergeSubscription(sub, other map[string]json.RawMessage) error {
+	if err := mergeSeenItems(sub, other); err != nil {
+		return err
+	}
+
+	type subscriptionState struct {
+		Template      string `json:"template"`
+		Broken        bool   `json:"broken"`
+		ErrorNoticeAt string `json:"error_notice_at"`
+		SnoozedUntil  string `json:"snoozed_until"`
+	}
+	decode := func(sub map[string]json.RawMessage) (state subscriptionState, err error) {
+		data, err := json.Marshal(sub)
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+		return state, err
+	}
+	ours, err := decode(sub)
+	if err != nil {
+		return err
+	}
+	theirs, err := decode(other)
+	if err != nil {
+		return err
+	}
+
+	merged := map[string]any{
+		"broken":          ours.Broken || theirs.Broken,
+		"error_notice_at": laterTime(ours.ErrorNoticeAt, theirs.ErrorNoticeAt),
+		"snoozed_until":   laterTime(ours.SnoozedUntil, theirs.SnoozedUntil),
+	}
+	if ours.Template == "" {
+		merged["template"] = theirs.Template
+	}
+	for key, v := range merged {
+		if v == "" || v == false {
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		sub[key] = raw
+	}
+	return nil
+}
+
+// laterTime returns the later of two RFC 3339 times, preferring one that
+// parses.
+func laterTime(a, b string) string {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errB == nil && (errA != nil || tb.After(ta)) {
+		return b
+	}
+	return a
+}
+
+// mergeSeenItems adds the seen items of other that sub lacks to sub, as
+// less recently seen than its own.
+func mergeSeenItems(sub, other map[string]json.RawMessage) error {
+	var ours, theirs []string
+	if raw, ok := sub["seen_items"]; ok {
+		if err := json.Unmarshal(raw, &ours); err != nil {
+			return err
+		}
+	}
+	if raw, ok := other["seen_items"]; ok {
+		if err := json.Unmarshal(raw, &theirs); err != nil {
+			return err
+		}
+	}
+
+	merged := make([]string, 0, len(ours)+len(theirs))
+	for _, id := range theirs {
+		if !slices.Contains(ours, id) && !slices.Contains(merged, id) {
+			merged = append(merged, id)
+		}
+	}
+	raw, err := json.Marshal(append(merged, ours...))
+	if err != nil {
+		return err
+	}
+	sub["seen_items"] = raw
+	return nil
+}
+
+func setDocumentKey(doc dbDocument, key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	doc[key] = raw
+	return nil
+}
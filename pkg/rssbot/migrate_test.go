@@ -0,0 +1,263 @@
+package rssbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// copyFixture copies a database file from testdata/db to a temporary
+// directory and returns its path there.
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "db", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "db.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrationsRegistered(t *testing.T) {
+	for v := range schemaVersion {
+		if _, ok := migrations[v]; !ok {
+			t.Errorf("No migration from database version %d", v)
+		}
+	}
+}
+
+// fixtureSubscription returns the subscription of a chat in a migrated
+// fixture to the feed most fixtures share, which user 1001 created.
+func fixtureSubscription(t *testing.T, db *Database, chatID int64) *Subscription {
+	t.Helper()
+	sub := db.Subscriptions[fmt.Sprint(chatID)]["https://example.com/feed.xml"]
+	if sub == nil {
+		t.Fatalf("No subscription for chat %d in %v", chatID, db.Subscriptions)
+	}
+	if sub.ChatID != chatID || sub.CreatedBy != 1001 {
+		t.Errorf("Unexpected owner of subscription %+v", sub)
+	}
+	return sub
+}
+
+func TestDatabaseMigrations(t *testing.T) {
+	tests := []struct {
+		fixture string
+		version int
+		check   func(t *testing.T, db *Database)
+	}{
+		{
+			fixture: "v0-baseline.json",
+			version: 0,
+			check: func(t *testing.T, db *Database) {
+				sub := fixtureSubscription(t, db, 1001)
+				if sub.FeedInfo.Title != "Example Blog" || sub.LastChecked != "2024-05-01T10:00:00Z" {
+					t.Errorf("Unexpected subscription %+v", sub)
+				}
+				// Subscriptions that only remembered the last item are
+				// primed again on their next check.
+				if len(sub.SeenItems) != 0 || !isNewSubscription(sub) {
+					t.Errorf("Expected no seen items, got %v", sub.SeenItems)
+				}
+				feedErr := db.FeedErrors["https://broken.example.org/rss"]
+				if feedErr == nil || feedErr.ErrorCount != 3 || feedErr.FirstErrorAt != "2024-05-01T08:00:00Z" {
+					t.Errorf("Unexpected feed error %+v", feedErr)
+				}
+			},
+		},
+		{
+			fixture: "v0-seen-items.json",
+			version: 0,
+			check: func(t *testing.T, db *Database) {
+				sub := fixtureSubscription(t, db, -2002)
+				want := []string{"https://example.com/posts/41", "https://example.com/posts/42"}
+				if !slices.Equal(sub.SeenItems, want) {
+					t.Errorf("Unexpected subscription %+v", sub)
+				}
+			},
+		},
+		{
+			fixture: "v0-feed-states.json",
+			version: 0,
+			check: func(t *testing.T, db *Database) {
+				sub := fixtureSubscription(t, db, 1001)
+				if !sub.Broken || sub.ErrorNoticeAt == "" || sub.SnoozedUntil != "2024-07-08T09:00:00Z" {
+					t.Errorf("Notice fields lost: %+v", sub)
+				}
+				state := db.FeedStates["https://example.com/feed.xml"]
+				if state == nil || state.ETag != `"abc123"` || state.TTL != 60 || state.Interval != 3600 ||
+					!slices.Equal(state.SkipHours, []int{0, 1}) || !slices.Equal(state.SkipDays, []string{"Sunday"}) {
+					t.Errorf("Unexpected feed state %+v", state)
+				}
+			},
+		},
+		{
+			fixture: "v0-chats.json",
+			version: 0,
+			check: func(t *testing.T, db *Database) {
+				if sub := fixtureSubscription(t, db, -2002); sub.Template != "{{.Title}} {{.Link}}" {
+					t.Errorf("Subscription template = %q", sub.Template)
+				}
+				settings := db.Chats["-2002"]
+				if settings == nil || settings.Timezone != "Europe/Berlin" || settings.Template != "<b>{{.Title}}</b>" {
+					t.Errorf("Unexpected chat settings %+v", settings)
+				}
+			},
+		},
+		{
+			fixture: "v1.json",
+			version: 1,
+			check: func(t *testing.T, db *Database) {
+				if len(db.Subscriptions) != 1 {
+					t.Errorf("Expected only chat -2002's subscriptions, got %v", db.Subscriptions)
+				}
+				sub := fixtureSubscription(t, db, -2002)
+				if !slices.Equal(sub.SeenItems, []string{"https://example.com/posts/42"}) || sub.Template == "" {
+					t.Errorf("Unexpected subscription %+v", sub)
+				}
+				if settings := db.Chats["-2002"]; settings == nil || settings.Timezone != "Europe/Berlin" {
+					t.Errorf("Unexpected chat settings %+v", settings)
+				}
+			},
+		},
+		{
+			// Two members of a group subscribed it to the same feeds.
+			fixture: "v1-group.json",
+			version: 1,
+			check: func(t *testing.T, db *Database) {
+				sub := fixtureSubscription(t, db, -2002)
+				want := []string{"https://example.com/posts/41", "https://example.com/posts/42", "https://example.com/posts/43"}
+				if !slices.Equal(sub.SeenItems, want) {
+					t.Errorf("SeenItems = %v, want %v", sub.SeenItems, want)
+				}
+				// The other member's template and error state carry over.
+				if sub.Template != "{{.Title}} {{.Link}}" || !sub.Broken ||
+					sub.ErrorNoticeAt != "2024-08-30T10:00:00Z" || sub.SnoozedUntil != "2024-09-06T10:00:00Z" {
+					t.Errorf("Unexpected merged subscription %+v", sub)
+				}
+				const newsURL = "https://news.example.net/rss"
+				if news := db.Subscriptions["-2002"][newsURL]; news == nil || news.CreatedBy != 1003 ||
+					!slices.Equal(news.SeenItems, []string{"news-1", "news-2"}) {
+					t.Errorf("Unexpected group subscription %+v", news)
+				}
+				if news := db.Subscriptions["1001"][newsURL]; news == nil || news.CreatedBy != 1001 {
+					t.Errorf("Expected the private subscription to stay separate, got %+v", news)
+				}
+				if _, ok := db.Subscriptions["1003"]; ok {
+					t.Error("Expected no subscriptions keyed by user 1003")
+				}
+			},
+		},
+		{
+			fixture: "v2.json",
+			version: 2,
+			check: func(t *testing.T, db *Database) {
+				if sub := fixtureSubscription(t, db, -2002); sub.LastChecked != "2024-10-01T10:00:00Z" {
+					t.Errorf("Unexpected subscription %+v", sub)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			path := copyFixture(t, tt.fixture)
+			original, _ := os.ReadFile(path)
+
+			db, err := NewDatabase(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if db.Version != schemaVersion {
+				t.Errorf("Version = %d, want %d", db.Version, schemaVersion)
+			}
+			tt.check(t, db)
+
+			old := fmt.Sprintf("%s.v%d", path, tt.version)
+			kept, err := os.ReadFile(old)
+			if tt.version == schemaVersion {
+				if err == nil {
+					t.Errorf("Expected no copy of a current database, found %s", old)
+				}
+				return
+			}
+			if err != nil || string(kept) != string(original) {
+				t.Errorf("Expected the original file kept as %s: %v", old, err)
+			}
+
+			// The migrated file is saved and loads as is.
+			var doc map[string]json.RawMessage
+			data, _ := os.ReadFile(path)
+			if err := json.Unmarshal(data, &doc); err != nil {
+				t.Fatal(err)
+			}
+			if string(doc["version"]) != fmt.Sprint(schemaVersion) {
+				t.Errorf("Saved version = %s, want %d", doc["version"], schemaVersion)
+			}
+			reopened, err := NewDatabase(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, reopened)
+		})
+	}
+}
+
+func TestDatabaseNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	newer := fmt.Sprintf(`{"version": %d, "subscriptions": {}}`, schemaVersion+1)
+	if err := os.WriteFile(path, []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A usable backup must not be loaded in its place either.
+	if err := os.WriteFile(backupPath(path, 1), []byte(`{"subscriptions": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDatabase(path); !errors.Is(err, errNewerSchema) {
+		t.Fatalf("NewDatabase() error = %v, want %v", err, errNewerSchema)
+	}
+	if data, _ := os.ReadFile(path); string(data) != newer {
+		t.Errorf("Database file changed to %s", data)
+	}
+}
+
+func TestDatabaseRecoveryMigration(t *testing.T) {
+	path := copyFixture(t, "v1.json")
+	backup, _ := os.ReadFile(path)
+	if err := os.Rename(path, backupPath(path, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"subscriptions": {`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub := fixtureSubscription(t, db, -2002); !slices.Equal(sub.SeenItems, []string{"https://example.com/posts/42"}) {
+		t.Errorf("Unexpected subscription %+v", sub)
+	}
+
+	// The backup is kept as it was before the migration, like a database
+	// migrated in place, and the migrated data is saved to path.
+	if kept, err := os.ReadFile(path + ".v1"); err != nil || string(kept) != string(backup) {
+		t.Errorf("Expected the backup kept as %s.v1: %v", path, err)
+	}
+	var doc map[string]json.RawMessage
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if string(doc["version"]) != fmt.Sprint(schemaVersion) {
+		t.Errorf("Saved version = %s, want %d", doc["version"], schemaVersion)
+	}
+}
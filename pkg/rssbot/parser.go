@@ -0,0 +1,98 @@
+package rssbot
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// Feed is a parsed feed, whatever format it was published in.
+type Feed struct {
+	// Format names the feed format that produced the feed, e.g. "rss".
+	Format string
+	Info   FeedInfo
+	// Items are newest first; see sortItemsByDate.
+	Items []FeedItem
+	// SelfURL is the location the feed declares for itself, e.g. through
+	// <itunes:new-feed-url> or a rel="self" link. It may be relative.
+	SelfURL string
+	// TTL (minutes), SkipHours and SkipDays are the publisher's polling
+	// hints.
+	TTL       int
+	SkipHours []int
+	SkipDays  []string
+}
+
+// feedFormat recognises and parses one feed format.
+type feedFormat struct {
+	name string
+	// sniff reports whether a document is in this format, given its root
+	// element (zero if the document isn't XML) and HTTP content type.
+	sniff func(root xml.Name, contentType string, data []byte) bool
+	parse func(data []byte) (*Feed, error)
+}
+
+// feedFormats is the registry consulted by parseFeed, in registration order.
+var feedFormats []feedFormat
+
+// registerFeedFormat makes a format available to parseFeed. It is meant to
+// be called from init functions.
+func registerFeedFormat(format feedFormat) {
+	feedFormats = append(feedFormats, format)
+}
+
+// rootElement returns a format sniffer that matches documents whose root
+// element has the given local name.
+func rootElement(local string) func(xml.Name, string, []byte) bool {
+	return func(root xml.Name, _ string, _ []byte) bool {
+		return root.Local == local
+	}
+}
+
+// parseFeed picks the registered format matching data and parses it. The
+// charset of contentType, if any, overrides the document's own. A feed
+// without a title or any items is rejected.
+func parseFeed(data []byte, contentType string) (*Feed, error) {
+	data = decodeCharset(trimPrologue(data), contentType)
+	root := sniffRoot(data)
+	for _, format := range feedFormats {
+		if !format.sniff(root, contentType, data) {
+			continue
+		}
+
+		feed, err := format.parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse feed: %w", err)
+		}
+		if feed.Info.Title == "" && len(feed.Items) == 0 {
+			return nil, fmt.Errorf("unable to parse feed: no title or items")
+		}
+		feed.Format = format.name
+		sortItemsByDate(feed.Items)
+		return feed, nil
+	}
+
+	return nil, fmt.Errorf("unable to parse feed")
+}
+
+// sniffRoot returns the name of the document's root element, or the zero
+// Name if data isn't XML.
+func sniffRoot(data []byte) xml.Name {
+	d := newLenientXMLDecoder(data)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.Name{}
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			return tok.Name
+		case xml.CharData:
+			// Text before the first element means this isn't XML, e.g.
+			// a JSON document that happens to contain markup.
+			if len(bytes.TrimSpace(tok)) > 0 {
+				return xml.Name{}
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package rssbot
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestParseFeedFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		contentType string
+		wantFormat  string
+		wantErr     bool
+	}{
+		{
+			name:       "RSS",
+			data:       `<?xml version="1.0"?><rss version="2.0"><channel><title>T</title></channel></rss>`,
+			wantFormat: "rss",
+		},
+		{
+			name:       "Atom",
+			data:       `<feed xmlns="http://www.w3.org/2005/Atom"><title>T</title></feed>`,
+			wantFormat: "atom",
+		},
+		{
+			name:       "RDF",
+			data:       `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><channel><title>T</title></channel></rdf:RDF>`,
+			wantFormat: "rdf",
+		},
+		{
+			name:        "JSON Feed",
+			data:        `{"version": "https://jsonfeed.org/version/1.1", "title": "T", "items": []}`,
+			contentType: "application/feed+json",
+			wantFormat:  "json",
+		},
+		{
+			name:        "HTML page",
+			data:        `<!DOCTYPE html><html><head><title>T</title></head></html>`,
+			contentType: "text/html",
+			wantErr:     true,
+		},
+		{
+			name:    "Feed without title or items",
+			data:    `<rss version="2.0"><channel></channel></rss>`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed([]byte(tt.data), tt.contentType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFeed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && feed.Format != tt.wantFormat {
+				t.Errorf("Format = %s, want %s", feed.Format, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestRegisterFeedFormat(t *testing.T) {
+	saved := feedFormats
+	defer func() { feedFormats = saved }()
+
+	registerFeedFormat(feedFormat{
+		name:  "test",
+		sniff: rootElement("test"),
+		parse: func(data []byte) (*Feed, error) {
+			return &Feed{Info: FeedInfo{Title: "Test"}, Items: []FeedItem{{GUID: "1"}}}, nil
+		},
+	})
+
+	feed, err := parseFeed([]byte(`<test/>`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Format != "test" || len(feed.Items) != 1 {
+		t.Errorf("Unexpected feed: %+v", feed)
+	}
+}
+
+func TestSniffRoot(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`<?xml version="1.0"?><!-- comment --><rss/>`, "rss"},
+		{"\n  <feed xmlns=\"http://www.w3.org/2005/Atom\"/>", "feed"},
+		{`{"content_html": "<p>hi</p>"}`, ""},
+		{`not xml`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := sniffRoot([]byte(tt.data)); got != (xml.Name{Space: got.Space, Local: tt.want}) {
+			t.Errorf("sniffRoot(%q) = %v, want %s", tt.data, got, tt.want)
+		}
+	}
+}
@@ -2,8 +2,13 @@ package rssbot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-telegram/bot"
@@ -11,20 +16,34 @@ import (
 )
 
 type Config struct {
-	DBPath        string
-	CheckInterval time.Duration
+	DBPath         string
+	CheckInterval  time.Duration
 	AllowedChatIDs []string
+	// Workers is the number of feeds checked concurrently. Values below 1
+	// are treated as 1.
+	Workers int
+	// MaxFeedErrors is the number of consecutive failed checks after which
+	// a feed is marked broken and its subscribers are notified. Values
+	// below 1 disable this.
+	MaxFeedErrors int
+	// FailureNoticeAfter is how long a feed must keep failing before its
+	// subscribers are warned, and how often the warning is repeated while
+	// it keeps failing. Zero disables the warnings.
+	FailureNoticeAfter time.Duration
+	// ExcerptLength is the maximum length, in characters, of the excerpt
+	// of the item's body included in notifications. Zero leaves it out.
+	ExcerptLength int
 }
 
 type Bot struct {
 	bot           *bot.Bot
-	db            *Database
+	db            Store
 	config        *Config
 	checkInterval time.Duration
 }
 
 func New(apiKey string, cfg *Config) (*Bot, error) {
-	db, err := NewDatabase(cfg.DBPath)
+	db, err := OpenStore(cfg.DBPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
@@ -53,18 +72,25 @@ func New(apiKey string, cfg *Config) (*Bot, error) {
 func (b *Bot) Run(ctx context.Context) error {
 	log.Println("Starting bot...")
 
-	go b.startFeedChecker(ctx)
+	checkerDone := make(chan struct{})
+	go func() {
+		defer close(checkerDone)
+		b.startFeedChecker(ctx)
+	}()
 
 	b.bot.Start(ctx)
 
-	return nil
+	// Let an in-progress check finish with the store before closing it.
+	<-checkerDone
+	return b.db.Close()
 }
 
 func (b *Bot) startFeedChecker(ctx context.Context) {
-	ticker := time.NewTicker(b.checkInterval)
+	tick := min(schedulerTick, b.checkInterval)
+	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 
-	log.Printf("Starting feed checker with interval %v", b.checkInterval)
+	log.Printf("Starting feed checker with default interval %v", b.checkInterval)
 
 	b.checkFeeds(ctx)
 
@@ -79,57 +105,389 @@ func (b *Bot) startFeedChecker(ctx context.Context) {
 	}
 }
 
+// checkFeeds checks every feed that is due according to its schedule.
 func (b *Bot) checkFeeds(ctx context.Context) {
-	log.Println("Checking feeds...")
-
 	subscriptions, err := b.db.GetAllSubscriptions()
 	if err != nil {
 		log.Printf("Error getting subscriptions: %v", err)
 		return
 	}
 
-	for _, sub := range subscriptions {
+	// Chats Telegram refuses to deliver to wait until they are heard from
+	// again; see markChatUnreachable.
+	subscriptions = slices.DeleteFunc(subscriptions, func(sub *Subscription) bool { return sub.Unreachable })
+
+	now := time.Now()
+	feeds := groupByFeed(subscriptions)
+	maps.DeleteFunc(feeds, func(feedURL string, subs []*Subscription) bool {
+		state, _ := b.db.GetFeedState(feedURL)
+		return !isDue(state, now)
+	})
+	if len(feeds) == 0 {
+		return
+	}
+
+	log.Printf("Checking %d feeds...", len(feeds))
+
+	feedURLs := make(chan string)
+	var wg sync.WaitGroup
+	for range max(b.config.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for feedURL := range feedURLs {
+				if err := b.checkFeed(ctx, feedURL, feeds[feedURL]); err != nil {
+					log.Printf("Error checking feed %s: %v", feedURL, err)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, feedURL := range slices.Sorted(maps.Keys(feeds)) {
 		select {
 		case <-ctx.Done():
-			return
-		default:
-			if err := b.checkFeed(ctx, sub); err != nil {
-				log.Printf("Error checking feed %s: %v", sub.FeedURL, err)
-			}
+			break dispatch
+		case feedURLs <- feedURL:
 		}
 	}
+	close(feedURLs)
+	wg.Wait()
+
+	if err := b.db.Flush(); err != nil {
+		log.Printf("Error saving database: %v", err)
+	}
 }
 
-func (b *Bot) checkFeed(ctx context.Context, sub *Subscription) error {
-	rssFeed, atomFeed, err := b.fetchFeed(ctx, sub.FeedURL)
-	if err != nil {
-		b.db.RecordFeedError(sub.FeedURL, err)
+// groupByFeed groups subscriptions by FeedURL so each feed is fetched once
+// per check no matter how many chats subscribe to it.
+func groupByFeed(subs []*Subscription) map[string][]*Subscription {
+	feeds := make(map[string][]*Subscription)
+	for _, sub := range subs {
+		feeds[sub.FeedURL] = append(feeds[sub.FeedURL], sub)
+	}
+	return feeds
+}
+
+// isNewSubscription reports whether sub has not completed its first check
+// yet. Subscriptions saved before Initialized existed count as initialized
+// once they have seen items.
+func isNewSubscription(sub *Subscription) bool {
+	return !sub.Initialized && len(sub.SeenItems) == 0
+}
+
+// checkFeed fetches feedURL once, delivers its new items to each of subs
+// and schedules the feed's next check.
+func (b *Bot) checkFeed(ctx context.Context, feedURL string, subs []*Subscription) error {
+	state, ok := b.db.GetFeedState(feedURL)
+	if !ok {
+		state = &FeedState{FeedURL: feedURL}
+	}
+	scheduled := state.NextCheck
+
+	foundNew, err := b.updateFeed(ctx, state, subs)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down; this says nothing about the feed.
 		return err
 	}
 
-	b.db.ClearFeedError(sub.FeedURL)
+	if err != nil {
+		b.db.RecordFeedError(feedURL, err)
+		feedErr, _ := b.db.GetFeedError(feedURL)
+		scheduleRetry(state, feedErr.ErrorCount, retryAfter(err), time.Now(), b.checkInterval)
+		if b.config.MaxFeedErrors > 0 && feedErr.ErrorCount >= b.config.MaxFeedErrors {
+			b.markFeedBroken(ctx, feedErr)
+		}
+		b.notifyLongFailure(ctx, subs, feedErr)
+	} else {
+		b.db.ClearFeedError(feedURL)
+		scheduleNextCheck(state, foundNew, time.Now(), b.checkInterval)
+		if slices.ContainsFunc(subs, func(sub *Subscription) bool { return sub.Broken }) {
+			b.db.SetFeedBroken(feedURL, false)
+		}
+	}
+
+	// The feed may have been rescheduled while it was being checked, e.g.
+	// by retryFeedNow for a new subscriber; don't push that back.
+	if current, ok := b.db.GetFeedState(feedURL); ok && current.NextCheck != scheduled {
+		state.NextCheck = earlierCheck(state.NextCheck, current.NextCheck)
+	}
+
+	if err == nil && state.MovedTo != "" && state.MovedTo != feedURL {
+		b.moveFeed(ctx, feedURL, state.MovedTo)
+		state.FeedURL = state.MovedTo
+	}
+
+	if saveErr := b.db.UpdateFeedState(state); saveErr != nil {
+		log.Printf("Failed to save state for %s: %v", feedURL, saveErr)
+	}
+
+	return err
+}
+
+// moveFeed migrates the subscriptions of a feed that has permanently moved
+// and tells each subscribing chat.
+func (b *Bot) moveFeed(ctx context.Context, oldURL, newURL string) {
+	log.Printf("Feed %s moved to %s", oldURL, newURL)
+
+	subs, err := b.db.MoveFeed(oldURL, newURL)
+	if err != nil {
+		log.Printf("Failed to move feed %s: %v", oldURL, err)
+	}
+
+	for _, sub := range subs {
+		b.sendFeedMoved(ctx, sub, oldURL)
+	}
+}
+
+// markFeedBroken flags the subscriptions to a persistently failing feed and
+// tells each subscribing chat about it once.
+func (b *Bot) markFeedBroken(ctx context.Context, feedErr *FeedError) {
+	subs, err := b.db.SetFeedBroken(feedErr.FeedURL, true)
+	if err != nil {
+		log.Printf("Failed to mark feed %s as broken: %v", feedErr.FeedURL, err)
+	}
+
+	for _, sub := range subs {
+		if sub.Unreachable {
+			continue
+		}
+		log.Printf("Feed %s marked broken for chat %d after %d errors", sub.FeedURL, sub.ChatID, feedErr.ErrorCount)
+		b.sendFeedBroken(ctx, sub, feedErr)
+	}
+}
+
+// markChatUnreachable pauses the subscriptions of a chat that Telegram
+// refuses to deliver to, as chatUnreachable tells from err, and tells the
+// users who subscribed it in private.
+func (b *Bot) markChatUnreachable(ctx context.Context, chatID int64, err error) {
+	subs, dbErr := b.db.SetChatUnreachable(chatID, true)
+	if dbErr != nil {
+		log.Printf("Failed to pause subscriptions of chat %d: %v", chatID, dbErr)
+	}
+	if len(subs) == 0 {
+		return
+	}
+	log.Printf("Pausing %d subscriptions of chat %d, which can't be delivered to: %v", len(subs), chatID, err)
+
+	byCreator := make(map[int64][]*Subscription)
+	for _, sub := range subs {
+		// A private chat's creator is the chat itself.
+		if sub.CreatedBy != 0 && sub.CreatedBy != chatID {
+			byCreator[sub.CreatedBy] = append(byCreator[sub.CreatedBy], sub)
+		}
+	}
+	for _, userID := range slices.Sorted(maps.Keys(byCreator)) {
+		b.sendChatUnreachable(ctx, userID, byCreator[userID], err)
+	}
+}
+
+// resumeChat lifts markChatUnreachable's pause on a chat that the bot has
+// heard from.
+func (b *Bot) resumeChat(chatID int64) {
+	subs, err := b.db.SetChatUnreachable(chatID, false)
+	if err != nil {
+		log.Printf("Failed to resume subscriptions of chat %d: %v", chatID, err)
+	}
+	if len(subs) > 0 {
+		log.Printf("Resuming %d subscriptions of chat %d", len(subs), chatID)
+	}
+}
+
+// chatUnreachable reports whether err from the Bot API means that nothing
+// can be delivered to the chat, because the bot was blocked or removed from
+// it or the chat no longer exists, rather than that one message failed.
+func chatUnreachable(err error) bool {
+	return errors.Is(err, bot.ErrorForbidden) ||
+		errors.Is(err, bot.ErrorBadRequest) && strings.Contains(err.Error(), "chat not found")
+}
+
+// messageRejected reports whether err from the Bot API means Telegram
+// refused the message itself, e.g. for markup it can't parse, so sending it
+// again would fail the same way.
+func messageRejected(err error) bool {
+	return errors.Is(err, bot.ErrorBadRequest) && !chatUnreachable(err)
+}
+
+// notifyLongFailure warns the subscribers of a feed that has been failing
+// for longer than Config.FailureNoticeAfter, once per period.
+func (b *Bot) notifyLongFailure(ctx context.Context, subs []*Subscription, feedErr *FeedError) {
+	now := time.Now()
+	for _, sub := range subs {
+		if !failureNoticeDue(sub, feedErr, b.config.FailureNoticeAfter, now) {
+			continue
+		}
+		if err := b.sendFailureNotice(ctx, sub, feedErr); err != nil {
+			continue
+		}
+		if err := b.db.RecordErrorNotice(sub.ChatID, sub.FeedURL); err != nil {
+			log.Printf("Failed to record error notice for %s: %v", sub.FeedURL, err)
+		}
+	}
+}
+
+// failureNoticeDue reports whether sub should be warned at now about a feed
+// failing since feedErr.FirstErrorAt.
+func failureNoticeDue(sub *Subscription, feedErr *FeedError, threshold time.Duration, now time.Time) bool {
+	if threshold <= 0 {
+		return false
+	}
+	first, err := time.Parse(time.RFC3339, feedErr.FirstErrorAt)
+	if err != nil || now.Sub(first) < threshold {
+		return false
+	}
+	if until, err := time.Parse(time.RFC3339, sub.SnoozedUntil); err == nil && now.Before(until) {
+		return false
+	}
+	last, err := time.Parse(time.RFC3339, sub.ErrorNoticeAt)
+	if err != nil || last.Before(first) {
+		// Never warned about this run of failures.
+		return true
+	}
+	return now.Sub(last) >= threshold
+}
+
+// retryFeedNow makes feedURL due on the next scheduler tick, even if a
+// check of it is in progress.
+func (b *Bot) retryFeedNow(feedURL string) error {
+	state, ok := b.db.GetFeedState(feedURL)
+	if !ok {
+		state = &FeedState{FeedURL: feedURL}
+	}
+	state.NextCheck = time.Now().UTC().Format(time.RFC3339)
+	return b.db.UpdateFeedState(state)
+}
+
+// updateFeed fetches the feed described by state and delivers new items to
+// subs. It reports whether the feed had items that were new to any of them.
+func (b *Bot) updateFeed(ctx context.Context, state *FeedState, subs []*Subscription) (bool, error) {
+	// A new subscriber needs the full feed to record what it holds, and one
+	// with unsent items needs them again even if the feed hasn't changed,
+	// so make an unconditional request.
+	etag, lastModified := state.ETag, state.LastModified
+	if slices.ContainsFunc(subs, needsFullFeed) {
+		state.ETag, state.LastModified = "", ""
+	}
 
-	var items []FeedItem
-	if rssFeed != nil {
-		items = b.extractRSSItems(rssFeed)
-	} else if atomFeed != nil {
-		items = b.extractAtomItems(atomFeed)
+	feed, err := b.fetchFeed(ctx, state.FeedURL, state)
+	if errors.Is(err, errNotModified) {
+		// Nothing to deliver, but checkFeed still follows a permanent
+		// redirect recorded in state.MovedTo.
+		return false, nil
+	}
+	if err != nil {
+		state.ETag, state.LastModified = etag, lastModified
+		return false, err
 	}
 
-	if len(items) == 0 {
-		return nil
+	// Publishers can also announce a new location in the feed itself. Only
+	// follow it once the new URL is known to serve the same feed, and don't
+	// probe a URL that didn't again.
+	if state.MovedTo == "" {
+		declared := declaredFeedURL(state.FeedURL, feed)
+		switch {
+		case declared == "" || declared == state.FeedURL:
+			state.FailedProbe = ""
+		case declared != state.FailedProbe:
+			probe := &FeedState{FeedURL: declared}
+			probed, err := b.fetchFeed(ctx, declared, probe)
+			switch {
+			case err == nil && probe.MovedTo != "":
+				err = fmt.Errorf("it redirects to %s", probe.MovedTo)
+			case err == nil && !sameFeed(feed, probed, declared):
+				err = errors.New("it serves a different feed")
+			}
+			switch {
+			case err == nil:
+				state.MovedTo, state.FailedProbe = declared, ""
+			case ctx.Err() == nil:
+				log.Printf("Feed %s declares %s as its URL, which doesn't serve it: %v", state.FeedURL, declared, err)
+				state.FailedProbe = declared
+			}
+		}
 	}
 
-	newestItem := items[0]
-	if newestItem.GUID != sub.LastItemGUID && sub.LastItemGUID != "" {
-		if err := b.sendFeedUpdate(ctx, sub, newestItem); err != nil {
-			log.Printf("Failed to send update for %s: %v", sub.FeedURL, err)
-			return err
+	// Deliver even when the feed is empty, so that new subscriptions
+	// complete their first check.
+	items := feed.Items
+	foundNew := false
+	for _, sub := range subs {
+		n, err := b.deliverNewItems(ctx, sub, items)
+		if n > 0 {
+			foundNew = true
+		}
+		if err != nil && chatUnreachable(err) {
+			b.markChatUnreachable(ctx, sub.ChatID, err)
+		} else if err != nil {
+			log.Printf("Failed to send update for %s to chat %d: %v", state.FeedURL, sub.ChatID, err)
 		}
 	}
 
-	b.db.UpdateLastChecked(sub.UserID, sub.FeedURL, newestItem.GUID)
-	return nil
+	return foundNew, nil
+}
+
+// maxDeliveryRetries is how many checks in a row fetch a feed in full to
+// retry items that failed to send to a subscriber. After that they wait
+// until the feed changes.
+const maxDeliveryRetries = 3
+
+// needsFullFeed reports whether sub needs the feed's items even if the feed
+// hasn't changed since it was last fetched.
+func needsFullFeed(sub *Subscription) bool {
+	return isNewSubscription(sub) || sub.UndeliveredChecks > 0 && sub.UndeliveredChecks <= maxDeliveryRetries
+}
+
+// deliverNewItems sends the items sub hasn't seen yet and records them as
+// seen. It returns the number of items delivered. Items Telegram rejects
+// (see messageRejected) are skipped and recorded as seen; after any other
+// failure the item and those after it stay unseen, to be retried on later
+// checks, and the error is returned.
+func (b *Bot) deliverNewItems(ctx context.Context, sub *Subscription, items []FeedItem) (int, error) {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = itemID(item)
+	}
+
+	// On the first check just remember what the feed holds; subscribing
+	// shouldn't flood the chat with the feed's back catalogue.
+	if isNewSubscription(sub) {
+		return 0, b.db.UpdateLastChecked(sub.ChatID, sub.FeedURL, ids)
+	}
+
+	seen := make(map[string]bool, len(sub.SeenItems))
+	for _, id := range sub.SeenItems {
+		seen[id] = true
+	}
+
+	unseen := newItemsSince(items, seen, maxNewItemsPerCheck)
+	delivered := 0
+	for i, item := range unseen {
+		err := b.sendFeedUpdate(ctx, sub, item)
+		if err == nil {
+			delivered++
+			continue
+		}
+		if messageRejected(err) {
+			log.Printf("Telegram rejected item %s of %s for chat %d, skipping it: %v", itemID(item), sub.FeedURL, sub.ChatID, err)
+			continue
+		}
+
+		pending := make(map[string]bool)
+		for _, item := range unseen[i:] {
+			pending[itemID(item)] = true
+		}
+		ids = slices.DeleteFunc(ids, func(id string) bool { return pending[id] })
+		b.db.UpdateLastChecked(sub.ChatID, sub.FeedURL, ids)
+		if !chatUnreachable(err) {
+			b.db.SetUndeliveredChecks(sub.ChatID, sub.FeedURL, sub.UndeliveredChecks+1)
+		}
+		return delivered, err
+	}
+
+	if sub.UndeliveredChecks > 0 {
+		b.db.SetUndeliveredChecks(sub.ChatID, sub.FeedURL, 0)
+	}
+	return delivered, b.db.UpdateLastChecked(sub.ChatID, sub.FeedURL, ids)
 }
 
 func (b *Bot) isChatAllowed(chatID string) bool {
@@ -13,17 +13,25 @@ func (src *Subscription) Clone() *Subscription {
 	}
 	dst := new(Subscription)
 	*dst = *src
+	dst.SeenItems = append(src.SeenItems[:0:0], src.SeenItems...)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SubscriptionCloneNeedsRegeneration = Subscription(struct {
-	UserID       int64
-	ChatID       int64
-	FeedURL      string
-	FeedInfo     FeedInfo
-	LastChecked  string
-	LastItemGUID string
+	ChatID            int64
+	FeedURL           string
+	CreatedBy         int64
+	FeedInfo          FeedInfo
+	LastChecked       string
+	Broken            bool
+	ErrorNoticeAt     string
+	SnoozedUntil      string
+	Unreachable       bool
+	SeenItems         []string
+	UndeliveredChecks int
+	Initialized       bool
+	Template          string
 }{})
 
 // Clone makes a deep copy of FeedInfo.
@@ -63,3 +71,49 @@ var _FeedErrorCloneNeedsRegeneration = FeedError(struct {
 	LastErrorAt  string
 	FirstErrorAt string
 }{})
+
+// Clone makes a deep copy of FeedState.
+// The result aliases no memory with the original.
+func (src *FeedState) Clone() *FeedState {
+	if src == nil {
+		return nil
+	}
+	dst := new(FeedState)
+	*dst = *src
+	dst.SkipHours = append(src.SkipHours[:0:0], src.SkipHours...)
+	dst.SkipDays = append(src.SkipDays[:0:0], src.SkipDays...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _FeedStateCloneNeedsRegeneration = FeedState(struct {
+	FeedURL      string
+	ETag         string
+	LastModified string
+	TTL          int
+	SkipHours    []int
+	SkipDays     []string
+	MaxAge       int
+	Interval     int64
+	NextCheck    string
+	FailedProbe  string
+	MovedTo      string
+}{})
+
+// Clone makes a deep copy of ChatSettings.
+// The result aliases no memory with the original.
+func (src *ChatSettings) Clone() *ChatSettings {
+	if src == nil {
+		return nil
+	}
+	dst := new(ChatSettings)
+	*dst = *src
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ChatSettingsCloneNeedsRegeneration = ChatSettings(struct {
+	ChatID   int64
+	Timezone string
+	Template string
+}{})
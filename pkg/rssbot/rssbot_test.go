@@ -0,0 +1,495 @@
+package rssbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestCheckFeedsFetchesEachFeedOnce(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Test RSS Feed</title>
+    <item>
+      <title>Test Item</title>
+      <link>https://example.com/item1</link>
+      <guid>item1</guid>
+    </item>
+  </channel>
+</rss>`))
+	}))
+	defer server.Close()
+
+	tmpFile, err := os.CreateTemp(t.TempDir(), "test-db-check-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	db, err := NewDatabase(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chatID := range []int64{1, 2, 3} {
+		if err := db.AddSubscription(&Subscription{ChatID: chatID, FeedURL: server.URL}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bot := &Bot{db: db, config: &Config{Workers: 2}}
+	bot.checkFeeds(context.Background())
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected feed to be fetched once, got %d requests", got)
+	}
+
+	subs, _ := db.GetAllSubscriptions()
+	for _, sub := range subs {
+		if len(sub.SeenItems) != 1 || sub.SeenItems[0] != "item1" {
+			t.Errorf("Expected chat %d to have seen item1, got %v", sub.ChatID, sub.SeenItems)
+		}
+	}
+}
+
+func TestFailureNoticeDue(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC)
+	feedErr := &FeedError{FirstErrorAt: "2025-06-05T12:00:00Z", LastError: "HTTP 500"}
+
+	tests := []struct {
+		name      string
+		sub       Subscription
+		threshold time.Duration
+		want      bool
+	}{
+		{"Disabled", Subscription{}, 0, false},
+		{"Failing for less than the threshold", Subscription{}, 7 * 24 * time.Hour, false},
+		{"Never warned", Subscription{}, 72 * time.Hour, true},
+		{"Warned about an earlier run of failures", Subscription{ErrorNoticeAt: "2025-05-01T12:00:00Z"}, 72 * time.Hour, true},
+		{"Warned recently", Subscription{ErrorNoticeAt: "2025-06-09T12:00:00Z"}, 72 * time.Hour, false},
+		{"Warned a full period ago", Subscription{ErrorNoticeAt: "2025-06-07T12:00:00Z"}, 72 * time.Hour, true},
+		{"Snoozed", Subscription{SnoozedUntil: "2025-06-11T12:00:00Z"}, 72 * time.Hour, false},
+		{"Snooze expired", Subscription{SnoozedUntil: "2025-06-09T12:00:00Z"}, 72 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failureNoticeDue(&tt.sub, feedErr, tt.threshold, now); got != tt.want {
+				t.Errorf("failureNoticeDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFeedEmptyFeedThenFirstItems(t *testing.T) {
+	items := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><title>Empty</title>` + items + `</channel></rss>`))
+	}))
+	defer server.Close()
+
+	fake := &fakeTelegram{}
+	b := newTestBot(t, fake)
+	if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		t.Helper()
+		subs, _ := b.db.GetChatSubscriptions(1)
+		if err := b.checkFeed(context.Background(), server.URL, subs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+	subs, _ := b.db.GetChatSubscriptions(1)
+	if isNewSubscription(subs[0]) {
+		t.Fatal("Expected a check of the empty feed to complete the first check")
+	}
+
+	// The feed's first items are new to the chat, not its back catalogue.
+	items = `<item><title>First</title><link>https://example.com/1</link><guid>1</guid></item>`
+	check()
+	if len(fake.calls) != 1 || !strings.Contains(fake.calls[0].Params["text"], "First") {
+		t.Errorf("Expected the first item to be sent, got %+v", fake.calls)
+	}
+}
+
+func TestCheckFeedsNewSubscriptionFollowsSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"Empty feed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Empty</title></channel></rss>`))
+		}},
+		{"Failing feed", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+
+			fake := &fakeTelegram{}
+			b := newTestBot(t, fake)
+			b.checkInterval = time.Hour
+			if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: server.URL}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Scheduler ticks after the first check must wait for the
+			// feed's next check.
+			for range 5 {
+				b.checkFeeds(context.Background())
+			}
+			if got := requests.Load(); got != 1 {
+				t.Errorf("Expected 1 request in 5 ticks, got %d", got)
+			}
+		})
+	}
+}
+
+func TestCheckFeedProbesDeclaredURLOnce(t *testing.T) {
+	var probes atomic.Int32
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel><title>Test</title>
+<atom:link href="` + server.URL + `/gone" rel="self"/><item><guid>1</guid></item></channel></rss>`))
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		http.NotFound(w, r)
+	})
+
+	b := newTestBot(t, &fakeTelegram{})
+	feedURL := server.URL + "/feed"
+	if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: feedURL}); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		subs, _ := b.db.GetChatSubscriptions(1)
+		if err := b.checkFeed(context.Background(), feedURL, subs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := probes.Load(); got != 1 {
+		t.Errorf("Expected the declared URL to be probed once, got %d probes", got)
+	}
+	if state, _ := b.db.GetFeedState(feedURL); state.FailedProbe != server.URL+"/gone" {
+		t.Errorf("FailedProbe = %q", state.FailedProbe)
+	}
+}
+
+func TestCheckFeedDeliveryFailures(t *testing.T) {
+	const (
+		unavailable = `{"ok": false, "error_code": 502, "description": "Bad Gateway"}`
+		rejected    = `{"ok": false, "error_code": 400, "description": "Bad Request: can't parse entities"}`
+		blocked     = `{"ok": false, "error_code": 403, "description": "Forbidden: bot was kicked from the group chat"}`
+		groupID     = -100
+		creator     = 7
+	)
+
+	var requests atomic.Int32
+	items := `<item><title>One</title><guid>1</guid></item>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss version="2.0"><channel><title>Test</title>` + items + `</channel></rss>`))
+	}))
+	defer server.Close()
+
+	// setup subscribes the group to the feed and completes its first
+	// check, then publishes two more items.
+	setup := func(t *testing.T, reject func(call telegramCall) string) (*Bot, *fakeTelegram) {
+		t.Helper()
+		items = `<item><title>One</title><guid>1</guid></item>`
+		fake := &fakeTelegram{reject: reject}
+		b := newTestBot(t, fake)
+		b.checkInterval = time.Hour
+		err := b.db.AddSubscription(&Subscription{
+			ChatID:    groupID,
+			FeedURL:   server.URL,
+			CreatedBy: creator,
+			FeedInfo:  FeedInfo{Title: "Test"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.checkFeeds(context.Background())
+		items = `<item><title>Three</title><guid>3</guid></item><item><title>Two</title><guid>2</guid></item>` + items
+		return b, fake
+	}
+	check := func(t *testing.T, b *Bot) (*Subscription, *FeedState) {
+		t.Helper()
+		subs, _ := b.db.GetChatSubscriptions(groupID)
+		if err := b.checkFeed(context.Background(), server.URL, subs); err != nil {
+			t.Fatal(err)
+		}
+		subs, _ = b.db.GetChatSubscriptions(groupID)
+		state, _ := b.db.GetFeedState(server.URL)
+		return subs[0], state
+	}
+
+	t.Run("Unavailable", func(t *testing.T) {
+		b, _ := setup(t, func(call telegramCall) string {
+			if strings.Contains(call.Params["text"], "Two") {
+				return unavailable
+			}
+			return ""
+		})
+		var sub *Subscription
+		var state *FeedState
+		for range 3 {
+			sub, state = check(t, b)
+		}
+		// Neither the failing item nor the one after it is delivered, and
+		// the feed isn't polled more often for them.
+		if slices.Contains(sub.SeenItems, "2") || slices.Contains(sub.SeenItems, "3") {
+			t.Errorf("Expected undelivered items to stay unseen, got %v", sub.SeenItems)
+		}
+		if state.Interval < 3600 {
+			t.Errorf("Interval = %d, want at least 3600", state.Interval)
+		}
+		if sub.Unreachable {
+			t.Error("Expected the chat to stay reachable")
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		b, fake := setup(t, func(call telegramCall) string {
+			if strings.Contains(call.Params["text"], "Two") {
+				return rejected
+			}
+			return ""
+		})
+		sub, state := check(t, b)
+		if !slices.Contains(sub.SeenItems, "2") || !slices.Contains(sub.SeenItems, "3") {
+			t.Errorf("Expected both items to be seen, got %v", sub.SeenItems)
+		}
+		last := fake.calls[len(fake.calls)-1]
+		if !strings.Contains(last.Params["text"], "Three") {
+			t.Errorf("Expected the item after the rejected one to be delivered, got %+v", last)
+		}
+		if state.Interval != 1800 {
+			t.Errorf("Interval = %d, want 1800 for the delivered item", state.Interval)
+		}
+	})
+
+	t.Run("Blocked", func(t *testing.T) {
+		b, fake := setup(t, func(call telegramCall) string {
+			if call.Params["chat_id"] == fmt.Sprint(groupID) {
+				return blocked
+			}
+			return ""
+		})
+		fake.calls = nil
+		sub, state := check(t, b)
+		if !sub.Unreachable {
+			t.Error("Expected the chat to be marked unreachable")
+		}
+		if state.Interval < 3600 {
+			t.Errorf("Interval = %d, want at least 3600", state.Interval)
+		}
+		var notices []telegramCall
+		for _, call := range fake.calls {
+			if call.Params["chat_id"] == fmt.Sprint(creator) {
+				notices = append(notices, call)
+			}
+		}
+		if len(notices) != 1 || !strings.Contains(notices[0].Params["text"], "Test") {
+			t.Errorf("Expected the creator to be told once, got %+v", notices)
+		}
+
+		// The paused subscription isn't checked until the chat is heard
+		// from again.
+		b.retryFeedNow(server.URL)
+		before := requests.Load()
+		b.checkFeeds(context.Background())
+		if got := requests.Load() - before; got != 0 {
+			t.Errorf("Expected no requests for an unreachable chat, got %d", got)
+		}
+		b.wrapHandler(b.handleListFeeds)(context.Background(), b.bot, &models.Update{Message: &models.Message{
+			Chat: models.Chat{ID: groupID},
+			From: &models.User{ID: creator},
+			Text: "/feeds",
+		}})
+		if subs, _ := b.db.GetChatSubscriptions(groupID); subs[0].Unreachable {
+			t.Error("Expected a message from the chat to resume its subscriptions")
+		}
+	})
+}
+
+func TestCheckFeedRetriesUndeliveredItems(t *testing.T) {
+	var unconditional atomic.Int32
+	version := "1"
+	items := `<item><title>One</title><guid>1</guid></item>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"v` + version + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "" {
+			unconditional.Add(1)
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss version="2.0"><channel><title>Test</title>` + items + `</channel></rss>`))
+	}))
+	defer server.Close()
+
+	var failing atomic.Bool
+	fake := &fakeTelegram{reject: func(call telegramCall) string {
+		if failing.Load() {
+			return `{"ok": false, "error_code": 502, "description": "Bad Gateway"}`
+		}
+		return ""
+	}}
+	b := newTestBot(t, fake)
+	if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		t.Helper()
+		subs, _ := b.db.GetChatSubscriptions(1)
+		if err := b.checkFeed(context.Background(), server.URL, subs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+	version, items = "2", `<item><title>Two</title><guid>2</guid></item>`+items
+	failing.Store(true)
+	unconditional.Store(0)
+	for range maxDeliveryRetries + 3 {
+		check()
+		if state, _ := b.db.GetFeedState(server.URL); state.ETag != `"v2"` {
+			t.Fatalf("Expected the server's validators to be kept, got %q", state.ETag)
+		}
+	}
+	// The unsent item is fetched again a bounded number of times, then
+	// waits for the feed to change.
+	if got := unconditional.Load(); got != maxDeliveryRetries {
+		t.Errorf("Expected %d unconditional requests, got %d", maxDeliveryRetries, got)
+	}
+
+	failing.Store(false)
+	version, items = "3", `<item><title>Three</title><guid>3</guid></item>`+items
+	fake.calls = nil
+	check()
+	subs, _ := b.db.GetChatSubscriptions(1)
+	if len(fake.calls) != 2 || !slices.Contains(subs[0].SeenItems, "2") || subs[0].UndeliveredChecks != 0 {
+		t.Errorf("Expected both new items delivered, got %d calls and %+v", len(fake.calls), subs[0])
+	}
+}
+
+func TestCheckFeedMovedNotModified(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusMovedPermanently))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		http.Error(w, "Expected a conditional request", http.StatusBadRequest)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	b := newTestBot(t, &fakeTelegram{})
+	oldURL, newURL := server.URL+"/old", server.URL+"/new"
+	if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: oldURL, SeenItems: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.UpdateFeedState(&FeedState{FeedURL: oldURL, ETag: `"v1"`}); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, _ := b.db.GetChatSubscriptions(1)
+	if err := b.checkFeed(context.Background(), oldURL, subs); err != nil {
+		t.Fatal(err)
+	}
+
+	if subs, _ := b.db.GetChatSubscriptions(1); len(subs) != 1 || subs[0].FeedURL != newURL {
+		t.Errorf("Expected the subscription to move to %s, got %+v", newURL, subs)
+	}
+	if state, ok := b.db.GetFeedState(newURL); !ok || state.ETag != `"v1"` {
+		t.Errorf("Expected the feed state to move with its validators, got %+v", state)
+	}
+}
+
+func TestCheckFeedDeclaredURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		newFeed   string
+		wantMoved bool
+	}{
+		{"Same items", `<item><guid>1</guid></item>`, true},
+		{"Declares the same URL", `<atom:link href="/new" rel="self"/><item><guid>2</guid></item>`, true},
+		{"Different feed", `<item><guid>other</guid></item>`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			serve := func(items string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/rss+xml")
+					w.Write([]byte(`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel><title>Test</title>` +
+						items + `</channel></rss>`))
+				}
+			}
+			mux.Handle("/old", serve(`<atom:link href="/new" rel="self"/><item><guid>1</guid></item>`))
+			mux.Handle("/new", serve(tt.newFeed))
+
+			b := newTestBot(t, &fakeTelegram{})
+			oldURL, newURL := server.URL+"/old", server.URL+"/new"
+			if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: oldURL}); err != nil {
+				t.Fatal(err)
+			}
+			subs, _ := b.db.GetChatSubscriptions(1)
+			if err := b.checkFeed(context.Background(), oldURL, subs); err != nil {
+				t.Fatal(err)
+			}
+
+			subs, _ = b.db.GetChatSubscriptions(1)
+			if moved := subs[0].FeedURL == newURL; moved != tt.wantMoved {
+				t.Errorf("Subscription follows %s, want moved = %v", subs[0].FeedURL, tt.wantMoved)
+			}
+			if state, _ := b.db.GetFeedState(oldURL); !tt.wantMoved && state.FailedProbe != newURL {
+				t.Errorf("Expected %s to be remembered as a failed probe, got %+v", newURL, state)
+			}
+		})
+	}
+}
@@ -8,9 +8,11 @@ package rssbot
 import (
 	"encoding/json"
 	"errors"
+
+	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Subscription,FeedInfo,FeedError
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Subscription,FeedInfo,FeedError,FeedState,ChatSettings
 
 // View returns a read-only view of Subscription.
 func (p *Subscription) View() SubscriptionView {
@@ -57,21 +59,35 @@ func (v *SubscriptionView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v SubscriptionView) UserID() int64        { return v.ж.UserID }
-func (v SubscriptionView) ChatID() int64        { return v.ж.ChatID }
-func (v SubscriptionView) FeedURL() string      { return v.ж.FeedURL }
-func (v SubscriptionView) FeedInfo() FeedInfo   { return v.ж.FeedInfo }
-func (v SubscriptionView) LastChecked() string  { return v.ж.LastChecked }
-func (v SubscriptionView) LastItemGUID() string { return v.ж.LastItemGUID }
+func (v SubscriptionView) ChatID() int64                  { return v.ж.ChatID }
+func (v SubscriptionView) FeedURL() string                { return v.ж.FeedURL }
+func (v SubscriptionView) CreatedBy() int64               { return v.ж.CreatedBy }
+func (v SubscriptionView) FeedInfo() FeedInfo             { return v.ж.FeedInfo }
+func (v SubscriptionView) LastChecked() string            { return v.ж.LastChecked }
+func (v SubscriptionView) Broken() bool                   { return v.ж.Broken }
+func (v SubscriptionView) ErrorNoticeAt() string          { return v.ж.ErrorNoticeAt }
+func (v SubscriptionView) SnoozedUntil() string           { return v.ж.SnoozedUntil }
+func (v SubscriptionView) Unreachable() bool              { return v.ж.Unreachable }
+func (v SubscriptionView) SeenItems() views.Slice[string] { return views.SliceOf(v.ж.SeenItems) }
+func (v SubscriptionView) UndeliveredChecks() int         { return v.ж.UndeliveredChecks }
+func (v SubscriptionView) Initialized() bool              { return v.ж.Initialized }
+func (v SubscriptionView) Template() string               { return v.ж.Template }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SubscriptionViewNeedsRegeneration = Subscription(struct {
-	UserID       int64
-	ChatID       int64
-	FeedURL      string
-	FeedInfo     FeedInfo
-	LastChecked  string
-	LastItemGUID string
+	ChatID            int64
+	FeedURL           string
+	CreatedBy         int64
+	FeedInfo          FeedInfo
+	LastChecked       string
+	Broken            bool
+	ErrorNoticeAt     string
+	SnoozedUntil      string
+	Unreachable       bool
+	SeenItems         []string
+	UndeliveredChecks int
+	Initialized       bool
+	Template          string
 }{})
 
 // View returns a read-only view of FeedInfo.
@@ -189,3 +205,131 @@ var _FeedErrorViewNeedsRegeneration = FeedError(struct {
 	LastErrorAt  string
 	FirstErrorAt string
 }{})
+
+// View returns a read-only view of FeedState.
+func (p *FeedState) View() FeedStateView {
+	return FeedStateView{ж: p}
+}
+
+// FeedStateView provides a read-only view over FeedState.
+//
+// Its methods should only be called if `Valid()` returns true.
+type FeedStateView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *FeedState
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v FeedStateView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v FeedStateView) AsStruct() *FeedState {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v FeedStateView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *FeedStateView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x FeedState
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v FeedStateView) FeedURL() string               { return v.ж.FeedURL }
+func (v FeedStateView) ETag() string                  { return v.ж.ETag }
+func (v FeedStateView) LastModified() string          { return v.ж.LastModified }
+func (v FeedStateView) TTL() int                      { return v.ж.TTL }
+func (v FeedStateView) SkipHours() views.Slice[int]   { return views.SliceOf(v.ж.SkipHours) }
+func (v FeedStateView) SkipDays() views.Slice[string] { return views.SliceOf(v.ж.SkipDays) }
+func (v FeedStateView) MaxAge() int                   { return v.ж.MaxAge }
+func (v FeedStateView) Interval() int64               { return v.ж.Interval }
+func (v FeedStateView) NextCheck() string             { return v.ж.NextCheck }
+func (v FeedStateView) FailedProbe() string           { return v.ж.FailedProbe }
+func (v FeedStateView) MovedTo() string               { return v.ж.MovedTo }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _FeedStateViewNeedsRegeneration = FeedState(struct {
+	FeedURL      string
+	ETag         string
+	LastModified string
+	TTL          int
+	SkipHours    []int
+	SkipDays     []string
+	MaxAge       int
+	Interval     int64
+	NextCheck    string
+	FailedProbe  string
+	MovedTo      string
+}{})
+
+// View returns a read-only view of ChatSettings.
+func (p *ChatSettings) View() ChatSettingsView {
+	return ChatSettingsView{ж: p}
+}
+
+// ChatSettingsView provides a read-only view over ChatSettings.
+//
+// Its methods should only be called if `Valid()` returns true.
+type ChatSettingsView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *ChatSettings
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v ChatSettingsView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v ChatSettingsView) AsStruct() *ChatSettings {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v ChatSettingsView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *ChatSettingsView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x ChatSettings
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v ChatSettingsView) ChatID() int64    { return v.ж.ChatID }
+func (v ChatSettingsView) Timezone() string { return v.ж.Timezone }
+func (v ChatSettingsView) Template() string { return v.ж.Template }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ChatSettingsViewNeedsRegeneration = ChatSettings(struct {
+	ChatID   int64
+	Timezone string
+	Template string
+}{})
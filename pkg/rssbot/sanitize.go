@@ -0,0 +1,442 @@
+package rssbot
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"golang.org/x/net/html"
+)
+
+// telegramTags maps the feed HTML elements that have a Telegram equivalent
+// to the tag used in Telegram's HTML parse mode.
+var telegramTags = map[string]string{
+	"b":          "b",
+	"strong":     "b",
+	"i":          "i",
+	"em":         "i",
+	"cite":       "i",
+	"var":        "i",
+	"u":          "u",
+	"ins":        "u",
+	"s":          "s",
+	"strike":     "s",
+	"del":        "s",
+	"code":       "code",
+	"kbd":        "code",
+	"samp":       "code",
+	"tt":         "code",
+	"pre":        "pre",
+	"blockquote": "blockquote",
+	"a":          "a",
+	"tg-spoiler": "tg-spoiler",
+	"h1":         "b",
+	"h2":         "b",
+	"h3":         "b",
+	"h4":         "b",
+	"h5":         "b",
+	"h6":         "b",
+}
+
+// blockBreaks is the number of line breaks separating each block element
+// from its surroundings.
+var blockBreaks = map[string]int{
+	"p":          2,
+	"h1":         2,
+	"h2":         2,
+	"h3":         2,
+	"h4":         2,
+	"h5":         2,
+	"h6":         2,
+	"blockquote": 2,
+	"pre":        2,
+	"ul":         2,
+	"ol":         2,
+	"dl":         2,
+	"table":      2,
+	"figure":     2,
+	"hr":         2,
+	"div":        1,
+	"section":    1,
+	"article":    1,
+	"header":     1,
+	"footer":     1,
+	"li":         1,
+	"dt":         1,
+	"dd":         1,
+	"tr":         1,
+	"figcaption": 1,
+}
+
+// hiddenElements are dropped along with their content.
+var hiddenElements = map[string]bool{
+	"head":     true,
+	"title":    true,
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"iframe":   true,
+	"object":   true,
+	"svg":      true,
+	"canvas":   true,
+	"video":    true,
+	"audio":    true,
+}
+
+// sanitizeHTML converts feed HTML to the subset Telegram's HTML parse mode
+// accepts: b, i, u, s, a, code, pre, blockquote and tg-spoiler. Other
+// formatting is mapped onto those where possible, headings become bold
+// lines, lists are flattened into "•" or numbered lines and everything else
+// is reduced to its text. Relative links are resolved against base.
+//
+// If limit is positive, the visible text is cut to at most limit UTF-16 code
+// units, as Telegram counts them, preferably at a word boundary, and
+// followed by "…". Tags left open by the cut are closed.
+func sanitizeHTML(body, base string, limit int) string {
+	s := &htmlSanitizer{base: base, limit: limit}
+	z := html.NewTokenizer(strings.NewReader(body))
+	for !s.done {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if s.hidden != "" {
+				if tok.Data == s.hidden && tt == html.StartTagToken {
+					s.hiddenDepth++
+				}
+				continue
+			}
+			if hiddenElements[tok.Data] && tt == html.StartTagToken {
+				s.hidden, s.hiddenDepth = tok.Data, 1
+				continue
+			}
+			s.startTag(tok, tt == html.SelfClosingTagToken)
+		case html.EndTagToken:
+			tok := z.Token()
+			if s.hidden != "" {
+				if tok.Data == s.hidden {
+					s.hiddenDepth--
+					if s.hiddenDepth == 0 {
+						s.hidden = ""
+					}
+				}
+				continue
+			}
+			s.endTag(tok.Data)
+		case html.TextToken:
+			if s.hidden == "" {
+				s.text(string(z.Text()))
+			}
+		}
+	}
+	s.closeAll()
+	return s.out.String()
+}
+
+// openElement is an element on the sanitizer's stack. tag is the Telegram
+// tag rendering it, or "" if it is rendered as plain text.
+type openElement struct {
+	name    string
+	tag     string
+	href    string
+	written bool
+}
+
+type htmlSanitizer struct {
+	base  string
+	limit int
+
+	out    strings.Builder
+	length int // visible text written, in UTF-16 code units
+	done   bool
+
+	open  []openElement
+	lists []int // next number of each open <ol>, or 0 for <ul>
+
+	started  bool // whether any text has been written
+	breaks   int  // line breaks owed before the next text
+	space    bool // space owed before the next text
+	prefixed bool // whether a list item prefix was just written
+
+	hidden      string // element whose content is being dropped
+	hiddenDepth int
+}
+
+func (s *htmlSanitizer) startTag(tok html.Token, selfClosing bool) {
+	name := tok.Data
+	if n, ok := blockBreaks[name]; ok {
+		if (name == "ul" || name == "ol") && len(s.lists) > 0 {
+			n = 1
+		}
+		s.lineBreak(n)
+	}
+
+	switch name {
+	case "br":
+		if s.started {
+			s.breaks = min(s.breaks+1, 2)
+		}
+	case "td", "th":
+		s.space = true
+	case "ul":
+		s.lists = append(s.lists, 0)
+	case "ol":
+		s.lists = append(s.lists, 1)
+	case "li":
+		s.listItem()
+	}
+	if selfClosing {
+		return
+	}
+
+	tag := telegramTags[name]
+	if name == "span" && strings.Contains(attr(tok, "class"), "spoiler") {
+		tag = "tg-spoiler"
+	}
+	if tag == "" {
+		return
+	}
+
+	elem := openElement{name: name, tag: tag}
+	if tag == "a" {
+		elem.href = s.linkTarget(attr(tok, "href"))
+		if elem.href == "" {
+			elem.tag = ""
+		}
+	}
+	if !s.canOpen(elem.tag) {
+		elem.tag = ""
+	}
+	s.open = append(s.open, elem)
+}
+
+func (s *htmlSanitizer) endTag(name string) {
+	for i := len(s.open) - 1; i >= 0; i-- {
+		if s.open[i].name == name {
+			s.closeFrom(i)
+			break
+		}
+	}
+
+	switch name {
+	case "ul", "ol":
+		if len(s.lists) > 0 {
+			s.lists = s.lists[:len(s.lists)-1]
+		}
+	}
+	if n, ok := blockBreaks[name]; ok {
+		if (name == "ul" || name == "ol") && len(s.lists) > 0 {
+			n = 1
+		}
+		s.lineBreak(n)
+	}
+}
+
+// canOpen reports whether tag may be opened inside the open elements.
+// Telegram doesn't allow entities inside code, nested blockquotes or nested
+// links, and nesting an entity in itself is pointless.
+func (s *htmlSanitizer) canOpen(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, e := range s.open {
+		if e.tag == tag || e.tag == "code" || e.tag == "pre" {
+			return false
+		}
+	}
+	return true
+}
+
+// linkTarget resolves href against the base URL and returns it if Telegram
+// can open it, or "".
+func (s *htmlSanitizer) linkTarget(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	href = resolveURL(s.base, href)
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto", "tg":
+		return href
+	}
+	return ""
+}
+
+func (s *htmlSanitizer) listItem() {
+	s.lineBreak(1)
+	prefix := "• "
+	if n := len(s.lists); n > 0 {
+		if s.lists[n-1] > 0 {
+			prefix = fmt.Sprintf("%d. ", s.lists[n-1])
+			s.lists[n-1]++
+		}
+		prefix = strings.Repeat("  ", n-1) + prefix
+	}
+	s.write(prefix)
+	s.prefixed = true
+}
+
+func (s *htmlSanitizer) lineBreak(n int) {
+	if s.started {
+		s.breaks = max(s.breaks, n)
+	}
+}
+
+// text adds character data, collapsing whitespace outside <pre>.
+func (s *htmlSanitizer) text(text string) {
+	if s.inPre() {
+		s.write(text)
+		return
+	}
+
+	var b strings.Builder
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			if b.Len() == 0 {
+				s.space = true
+			} else {
+				space = true
+			}
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	s.write(b.String())
+	if space {
+		s.space = true
+	}
+}
+
+// write adds visible text, preceded by the line breaks or space owed and by
+// the open tags not written yet. Tags are written lazily so that elements
+// without text don't produce empty entities.
+func (s *htmlSanitizer) write(text string) {
+	if text == "" || s.done {
+		return
+	}
+	if s.started {
+		switch {
+		case s.breaks > 0:
+			s.emit(strings.Repeat("\n", s.breaks))
+		case s.space && !s.prefixed:
+			s.emit(" ")
+		}
+	}
+	s.breaks, s.space, s.prefixed = 0, false, false
+
+	for i := range s.open {
+		e := &s.open[i]
+		if e.tag == "" || e.written || s.done {
+			continue
+		}
+		if e.tag == "a" {
+			fmt.Fprintf(&s.out, `<a href="%s">`, escapeHTML(e.href))
+		} else {
+			fmt.Fprintf(&s.out, "<%s>", e.tag)
+		}
+		e.written = true
+	}
+
+	s.emit(text)
+	s.started = true
+}
+
+// emit writes escaped text, cutting it and finishing the output once the
+// limit is reached. One code unit is kept in reserve for the ellipsis.
+func (s *htmlSanitizer) emit(text string) {
+	if s.done {
+		return
+	}
+	n := utf16Len(text)
+	if s.limit <= 0 || s.length+n <= s.limit-1 {
+		s.out.WriteString(escapeHTML(text))
+		s.length += n
+		return
+	}
+
+	text = cutUTF16(text, s.limit-1-s.length, !s.inPre())
+	s.out.WriteString(escapeHTML(text) + "…")
+	s.length += utf16Len(text) + 1
+	s.done = true
+}
+
+func (s *htmlSanitizer) inPre() bool {
+	for _, e := range s.open {
+		if e.name == "pre" {
+			return true
+		}
+	}
+	return false
+}
+
+// closeFrom closes the elements from index i up.
+func (s *htmlSanitizer) closeFrom(i int) {
+	for j := len(s.open) - 1; j >= i; j-- {
+		if s.open[j].written {
+			fmt.Fprintf(&s.out, "</%s>", s.open[j].tag)
+		}
+	}
+	s.open = s.open[:i]
+}
+
+func (s *htmlSanitizer) closeAll() {
+	s.closeFrom(0)
+}
+
+// cutUTF16 returns the longest prefix of s that is at most n UTF-16 code
+// units long, never splitting a character. With words set, a cut inside a
+// word backs up to the preceding space, if there is one.
+func cutUTF16(s string, n int, words bool) string {
+	if n <= 0 {
+		return ""
+	}
+	units := 0
+	for i, r := range s {
+		units += utf16.RuneLen(r)
+		if units <= n {
+			continue
+		}
+		prefix := s[:i]
+		if words && !unicode.IsSpace(r) {
+			if j := strings.LastIndexFunc(prefix, unicode.IsSpace); j > 0 {
+				prefix = prefix[:j]
+			}
+		}
+		return strings.TrimRightFunc(prefix, unicode.IsSpace)
+	}
+	return s
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit of
+// Telegram's message limits.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,192 @@
+package rssbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "Supported tags",
+			in:   `<b>bold</b> <i>italic</i> <u>under</u> <s>strike</s> <code>x := 1</code>`,
+			want: `<b>bold</b> <i>italic</i> <u>under</u> <s>strike</s> <code>x := 1</code>`,
+		},
+		{
+			name: "Mapped tags",
+			in:   `<strong>a</strong> <em>b</em> <del>c</del> <ins>d</ins> <kbd>e</kbd> <span class="spoiler">f</span>`,
+			want: `<b>a</b> <i>b</i> <s>c</s> <u>d</u> <code>e</code> <tg-spoiler>f</tg-spoiler>`,
+		},
+		{
+			name: "Unsupported tags and attributes dropped",
+			in:   `<p class="intro" style="color:red"><span>Hello</span> <font color="red">world</font><img src="x.png"></p>`,
+			want: `Hello world`,
+		},
+		{
+			name: "Paragraphs and line breaks",
+			in:   "<p>First\n   paragraph</p><p>Second<br>line</p><div>Third</div>",
+			want: "First paragraph\n\nSecond\nline\n\nThird",
+		},
+		{
+			name: "Headings",
+			in:   `<h2>Title</h2><p>Body</p>`,
+			want: "<b>Title</b>\n\nBody",
+		},
+		{
+			name: "Lists",
+			in:   `<p>Intro</p><ul><li>One</li><li> Two <ol><li>Nested</li><li>Again</li></ol></li></ul><p>End</p>`,
+			want: "Intro\n\n• One\n• Two\n  1. Nested\n  2. Again\n\nEnd",
+		},
+		{
+			name: "Links",
+			in:   `<a href="/post?a=1&amp;b=2">relative</a> <a href="javascript:alert(1)">script</a> <a>bare</a>`,
+			want: `<a href="https://example.com/post?a=1&amp;b=2">relative</a> script bare`,
+		},
+		{
+			name: "Nested links",
+			in:   `<a href="https://a.example">outer <a href="https://b.example">inner</a></a>`,
+			want: `<a href="https://a.example">outer inner</a>`,
+		},
+		{
+			name: "Entities escaped",
+			in:   `Fish &amp; chips &lt;3 &nbsp;&quot;quoted&quot; 5 > 4`,
+			want: `Fish &amp; chips &lt;3 &#34;quoted&#34; 5 &gt; 4`,
+		},
+		{
+			name: "Pre keeps whitespace and drops formatting",
+			in:   "<pre>func main() {\n    <b>fmt</b>.Println()\n}</pre>",
+			want: "<pre>func main() {\n    fmt.Println()\n}</pre>",
+		},
+		{
+			name: "Nested blockquote",
+			in:   `<blockquote>Outer <blockquote>inner</blockquote></blockquote>`,
+			want: "<blockquote>Outer\n\ninner</blockquote>",
+		},
+		{
+			name: "Hidden elements",
+			in:   `<style>p { color: red }</style><script>alert("x")</script><p>Visible</p><svg><text>Hidden</text></svg>`,
+			want: `Visible`,
+		},
+		{
+			name: "Unclosed and stray tags",
+			in:   `<b>bold <i>both</b> plain</i></u>`,
+			want: `<b>bold <i>both</i></b> plain`,
+		},
+		{
+			name: "Empty elements",
+			in:   `<b></b><p> </p><i> </i>Text`,
+			want: `Text`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeHTML(tt.in, "https://example.com/posts/1", 0); got != tt.want {
+				t.Errorf("sanitizeHTML() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeHTMLLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		limit int
+		want  string
+	}{
+		{
+			name:  "Fits",
+			in:    `<p>Short text</p>`,
+			limit: 20,
+			want:  "Short text",
+		},
+		{
+			name:  "Word boundary",
+			in:    `<p>The quick brown fox jumps</p>`,
+			limit: 14,
+			want:  "The quick…",
+		},
+		{
+			name:  "Open tags closed",
+			in:    `<p><b>Bold <i>and italic text</i></b> after</p>`,
+			limit: 12,
+			want:  "<b>Bold <i>and…</i></b>",
+		},
+		{
+			name:  "Entities not split",
+			in:    `a &amp; b &amp; c`,
+			limit: 5,
+			want:  "a &amp;…",
+		},
+		{
+			name:  "Surrogate pairs not split",
+			in:    `😀😀😀`,
+			limit: 4,
+			want:  "😀…",
+		},
+		{
+			name:  "Single long word",
+			in:    `Supercalifragilistic`,
+			limit: 6,
+			want:  "Super…",
+		},
+		{
+			name:  "Limit at paragraph break",
+			in:    `<p>One</p><p>Two</p>`,
+			limit: 5,
+			want:  "One…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeHTML(tt.in, "", tt.limit)
+			if got != tt.want {
+				t.Errorf("sanitizeHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutUTF16(t *testing.T) {
+	tests := []struct {
+		s     string
+		n     int
+		words bool
+		want  string
+	}{
+		{"hello world", 20, true, "hello world"},
+		{"hello world", 8, true, "hello"},
+		{"hello world", 8, false, "hello wo"},
+		{"hello world", 5, true, "hello"},
+		{"a😀b", 2, false, "a"},
+		{"a😀b", 3, false, "a😀"},
+		{"abc", 0, false, ""},
+	}
+
+	for _, tt := range tests {
+		if got := cutUTF16(tt.s, tt.n, tt.words); got != tt.want {
+			t.Errorf("cutUTF16(%q, %d, %v) = %q, want %q", tt.s, tt.n, tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestItemExcerpt(t *testing.T) {
+	item := FeedItem{Content: "Line one\nLine <two>", ContentType: contentText}
+	if got, want := itemExcerpt(item, 100), "Line one\nLine &lt;two&gt;"; got != want {
+		t.Errorf("itemExcerpt() = %q, want %q", got, want)
+	}
+	if got := itemExcerpt(item, 0); got != "" {
+		t.Errorf("Expected no excerpt with limit 0, got %q", got)
+	}
+
+	long := FeedItem{Content: "<p>" + strings.Repeat("word ", 100) + "</p>", ContentType: contentHTML}
+	if got := itemExcerpt(long, 50); utf16Len(got) > 50 || !strings.HasSuffix(got, "…") {
+		t.Errorf("Expected excerpt of at most 50 characters ending in an ellipsis, got %q", got)
+	}
+}
@@ -0,0 +1,161 @@
+package rssbot
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// minCheckInterval and maxCheckInterval bound the adaptive polling
+	// interval. Config.CheckInterval widens them if it lies outside.
+	minCheckInterval = 5 * time.Minute
+	maxCheckInterval = 24 * time.Hour
+
+	// maxRetryAfter bounds how long a Retry-After header can delay a
+	// feed.
+	maxRetryAfter = 7 * 24 * time.Hour
+
+	// schedulerTick is how often the checker wakes up to look for due
+	// feeds.
+	schedulerTick = time.Minute
+)
+
+// isDue reports whether the feed described by state should be checked at
+// now. Feeds that have never been scheduled are always due.
+func isDue(state *FeedState, now time.Time) bool {
+	if state == nil || state.NextCheck == "" {
+		return true
+	}
+	next, err := time.Parse(time.RFC3339, state.NextCheck)
+	if err != nil {
+		return true
+	}
+	return !now.Before(next)
+}
+
+// scheduleNextCheck adapts the feed's polling interval to how often it
+// publishes and records when it is next due. The interval halves after a
+// check that found new items and grows by half after one that didn't. The
+// publisher's <ttl> and Cache-Control max-age are honoured as a lower bound,
+// and <skipHours>/<skipDays> push the check out of the skipped period.
+func scheduleNextCheck(state *FeedState, foundNew bool, now time.Time, base time.Duration) {
+	lo := min(minCheckInterval, base)
+	hi := max(maxCheckInterval, base)
+
+	interval := time.Duration(state.Interval) * time.Second
+	switch {
+	case interval == 0:
+		interval = base
+	case foundNew:
+		interval /= 2
+	default:
+		interval += interval / 2
+	}
+	interval = min(max(interval, lo), hi)
+	state.Interval = int64(interval / time.Second)
+
+	wait := interval
+	if ttl := time.Duration(state.TTL) * time.Minute; ttl > wait {
+		wait = min(ttl, hi)
+	}
+	if maxAge := time.Duration(state.MaxAge) * time.Second; maxAge > wait {
+		wait = min(maxAge, hi)
+	}
+
+	next := skipAhead(now.Add(wait), state.SkipHours, state.SkipDays)
+	state.NextCheck = next.UTC().Format(time.RFC3339)
+}
+
+// earlierCheck returns whichever of two NextCheck values is due first. An
+// empty or invalid one is due right away.
+func earlierCheck(a, b string) string {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	switch {
+	case errA != nil:
+		return a
+	case errB != nil || tb.Before(ta):
+		return b
+	}
+	return a
+}
+
+// scheduleRetry records when a feed that failed its last errorCount checks
+// in a row is next due. The delay doubles with every failure, starting at
+// base and capped like the polling interval, and is never shorter than the
+// server's Retry-After. The adaptive interval itself is left untouched.
+func scheduleRetry(state *FeedState, errorCount int, retryAfter time.Duration, now time.Time, base time.Duration) {
+	hi := max(maxCheckInterval, base)
+
+	delay := base
+	for i := 1; i < errorCount && delay < hi; i++ {
+		delay *= 2
+	}
+	delay = min(delay, hi)
+	delay = max(delay, min(retryAfter, maxRetryAfter))
+
+	state.NextCheck = now.Add(delay).UTC().Format(time.RFC3339)
+}
+
+// retryAfter returns the delay requested by the server in a failed fetch's
+// Retry-After header, or 0.
+func retryAfter(err error) time.Duration {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header value, given either as a
+// number of seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// skipAhead moves t forward to the start of the first hour that is not
+// listed in skipHours or skipDays. Both are interpreted in GMT, as the RSS
+// specification requires.
+func skipAhead(t time.Time, skipHours []int, skipDays []string) time.Time {
+	orig := t
+	t = t.UTC()
+	// A week of hours covers every combination; if everything is skipped
+	// the feed is misconfigured and we ignore the hints.
+	for range 7 * 24 {
+		if !slices.Contains(skipHours, t.Hour()) && !slices.ContainsFunc(skipDays, func(day string) bool {
+			return strings.EqualFold(strings.TrimSpace(day), t.Weekday().String())
+		}) {
+			return t
+		}
+		t = t.Truncate(time.Hour).Add(time.Hour)
+	}
+	return orig
+}
+
+// cacheMaxAge returns the max-age directive of the response's Cache-Control
+// header in seconds, or 0 if there is none.
+func cacheMaxAge(header http.Header) int {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "max-age") {
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds > 0 {
+				return seconds
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,306 @@
+package rssbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduleNextCheck(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 30, 0, 0, time.UTC) // a Monday
+
+	tests := []struct {
+		name         string
+		state        FeedState
+		foundNew     bool
+		wantInterval time.Duration
+		wantNext     time.Time
+	}{
+		{
+			name:         "First check uses the default interval",
+			state:        FeedState{},
+			wantInterval: time.Hour,
+			wantNext:     now.Add(time.Hour),
+		},
+		{
+			name:         "New items halve the interval",
+			state:        FeedState{Interval: 3600},
+			foundNew:     true,
+			wantInterval: 30 * time.Minute,
+			wantNext:     now.Add(30 * time.Minute),
+		},
+		{
+			name:         "No new items grow the interval",
+			state:        FeedState{Interval: 3600},
+			wantInterval: 90 * time.Minute,
+			wantNext:     now.Add(90 * time.Minute),
+		},
+		{
+			name:         "Interval is clamped to the minimum",
+			state:        FeedState{Interval: 360},
+			foundNew:     true,
+			wantInterval: minCheckInterval,
+			wantNext:     now.Add(minCheckInterval),
+		},
+		{
+			name:         "Interval is clamped to the maximum",
+			state:        FeedState{Interval: 20 * 3600},
+			wantInterval: maxCheckInterval,
+			wantNext:     now.Add(maxCheckInterval),
+		},
+		{
+			name:         "TTL delays the next check",
+			state:        FeedState{Interval: 3600, TTL: 180},
+			foundNew:     true,
+			wantInterval: 30 * time.Minute,
+			wantNext:     now.Add(3 * time.Hour),
+		},
+		{
+			name:         "Cache-Control max-age delays the next check",
+			state:        FeedState{Interval: 3600, MaxAge: 7200},
+			foundNew:     true,
+			wantInterval: 30 * time.Minute,
+			wantNext:     now.Add(2 * time.Hour),
+		},
+		{
+			name:         "Skipped hours are avoided",
+			state:        FeedState{Interval: 3600, SkipHours: []int{11, 12}},
+			wantInterval: 90 * time.Minute,
+			wantNext:     time.Date(2025, 6, 2, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name:         "Skipped days are avoided",
+			state:        FeedState{Interval: 3600, SkipDays: []string{"Monday"}},
+			wantInterval: 90 * time.Minute,
+			wantNext:     time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := tt.state
+			scheduleNextCheck(&state, tt.foundNew, now, time.Hour)
+
+			if got := time.Duration(state.Interval) * time.Second; got != tt.wantInterval {
+				t.Errorf("Interval = %v, want %v", got, tt.wantInterval)
+			}
+			if want := tt.wantNext.Format(time.RFC3339); state.NextCheck != want {
+				t.Errorf("NextCheck = %s, want %s", state.NextCheck, want)
+			}
+		})
+	}
+}
+
+func TestIsDue(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 30, 0, 0, time.UTC)
+
+	if !isDue(nil, now) {
+		t.Error("Expected unknown feed to be due")
+	}
+	if !isDue(&FeedState{NextCheck: "2025-06-02T10:00:00Z"}, now) {
+		t.Error("Expected feed scheduled in the past to be due")
+	}
+	if isDue(&FeedState{NextCheck: "2025-06-02T11:00:00Z"}, now) {
+		t.Error("Expected feed scheduled in the future not to be due")
+	}
+}
+
+func TestSkipAheadEverythingSkipped(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 30, 0, 0, time.UTC)
+	days := []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+	if got := skipAhead(now, nil, days); !got.Equal(now) {
+		t.Errorf("Expected hints to be ignored, got %v", got)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", 0},
+		{"no-cache", 0},
+		{"max-age=600", 600},
+		{"public, max-age=3600, must-revalidate", 3600},
+		{"public, MAX-AGE=\"120\"", 120},
+		{"max-age=bogus", 0},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("Cache-Control", tt.header)
+		if got := cacheMaxAge(header); got != tt.want {
+			t.Errorf("cacheMaxAge(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestFetchFeedRecordsPollingHints(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Test RSS Feed</title>
+    <ttl> 60 </ttl>
+    <skipHours><hour>1</hour><hour>2</hour></skipHours>
+    <skipDays><day>Sunday</day></skipDays>
+    <item><title>Item</title><guid>item1</guid></item>
+  </channel>
+</rss>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write([]byte(feed))
+	}))
+	defer server.Close()
+
+	state := &FeedState{FeedURL: server.URL}
+	if _, err := (&Bot{}).fetchFeed(t.Context(), server.URL, state); err != nil {
+		t.Fatal(err)
+	}
+
+	if state.TTL != 60 || state.MaxAge != 300 {
+		t.Errorf("Expected TTL 60 and MaxAge 300, got %d and %d", state.TTL, state.MaxAge)
+	}
+	if !slices.Equal(state.SkipHours, []int{1, 2}) || !slices.Equal(state.SkipDays, []string{"Sunday"}) {
+		t.Errorf("Unexpected skip hints: %v %v", state.SkipHours, state.SkipDays)
+	}
+}
+
+func TestScheduleRetry(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		errorCount int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{"First failure", 1, 0, time.Hour},
+		{"Backoff doubles", 3, 0, 4 * time.Hour},
+		{"Backoff is capped", 10, 0, maxCheckInterval},
+		{"Retry-After extends the delay", 1, 3 * time.Hour, 3 * time.Hour},
+		{"Retry-After shorter than backoff", 3, time.Minute, 4 * time.Hour},
+		{"Retry-After is capped", 1, 30 * 24 * time.Hour, maxRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &FeedState{Interval: 1800}
+			scheduleRetry(state, tt.errorCount, tt.retryAfter, now, time.Hour)
+
+			if want := now.Add(tt.want).Format(time.RFC3339); state.NextCheck != want {
+				t.Errorf("NextCheck = %s, want %s", state.NextCheck, want)
+			}
+			if state.Interval != 1800 {
+				t.Errorf("Expected interval to be unchanged, got %d", state.Interval)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Mon, 02 Jun 2025 11:30:00 GMT", time.Hour},
+		{"Mon, 02 Jun 2025 09:30:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFetchFeedRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := (&Bot{}).fetchFeed(t.Context(), server.URL, nil)
+	if err == nil || err.Error() != "HTTP 429" {
+		t.Fatalf("Expected HTTP 429 error, got %v", err)
+	}
+	if got := retryAfter(err); got != 10*time.Minute {
+		t.Errorf("Expected Retry-After of 10m, got %v", got)
+	}
+}
+
+func TestCheckFeedsRetryAfterNewSubscription(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	b := newTestBot(t, &fakeTelegram{})
+	b.checkInterval = time.Hour
+	// A subscription whose feed has never been fetched successfully.
+	if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for range 5 {
+		b.checkFeeds(t.Context())
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected 1 request in 5 ticks, got %d", got)
+	}
+	state, _ := b.db.GetFeedState(server.URL)
+	next, err := time.Parse(time.RFC3339, state.NextCheck)
+	if err != nil || next.Before(start.Add(23*time.Hour)) {
+		t.Errorf("Expected the next check a day ahead, got %q", state.NextCheck)
+	}
+	if feedErr, _ := b.db.GetFeedError(server.URL); feedErr.ErrorCount != 1 {
+		t.Errorf("Expected 1 recorded error, got %d", feedErr.ErrorCount)
+	}
+}
+
+func TestCheckFeedKeepsRetryDuringCheck(t *testing.T) {
+	b := newTestBot(t, &fakeTelegram{})
+	b.checkInterval = time.Hour
+	var feedURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Another chat subscribes while the feed is being fetched.
+		if err := b.retryFeedNow(feedURL); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss version="2.0"><channel><title>Test</title><item><guid>1</guid></item></channel></rss>`))
+	}))
+	defer server.Close()
+	feedURL = server.URL
+	if err := b.db.AddSubscription(&Subscription{ChatID: 1, FeedURL: feedURL}); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, _ := b.db.GetChatSubscriptions(1)
+	if err := b.checkFeed(t.Context(), feedURL, subs); err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := b.db.GetFeedState(feedURL)
+	if !isDue(state, time.Now().Add(schedulerTick)) {
+		t.Errorf("Expected the feed to be due on the next tick, next check is %q", state.NextCheck)
+	}
+	if state.Interval != 3600 {
+		t.Errorf("Interval = %d, want 3600", state.Interval)
+	}
+}
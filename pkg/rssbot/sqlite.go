@@ -0,0 +1,628 @@
+package rssbot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations create and upgrade the SQLite store's tables:
+// sqliteMigrations[i] upgrades a database whose user_version is i, and
+// NewSQLiteStore applies those a database hasn't had yet. Seen items are
+// kept in their own table, ordered by seq within each subscription, so that
+// a check only writes the identities that changed.
+var sqliteMigrations = []string{
+	// 0 to 1: the original tables. Stores created before the schema was
+	// versioned have them already.
+	`
+CREATE TABLE IF NOT EXISTS subscriptions (
+	user_id         INTEGER NOT NULL,
+	chat_id         INTEGER NOT NULL,
+	feed_url        TEXT    NOT NULL,
+	title           TEXT    NOT NULL DEFAULT '',
+	description     TEXT    NOT NULL DEFAULT '',
+	link            TEXT    NOT NULL DEFAULT '',
+	last_checked    TEXT    NOT NULL DEFAULT '',
+	broken          INTEGER NOT NULL DEFAULT 0,
+	error_notice_at TEXT    NOT NULL DEFAULT '',
+	snoozed_until   TEXT    NOT NULL DEFAULT '',
+	template        TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (user_id, feed_url)
+);
+CREATE INDEX IF NOT EXISTS subscriptions_feed_url ON subscriptions (feed_url);
+
+CREATE TABLE IF NOT EXISTS seen_items (
+	user_id  INTEGER NOT NULL,
+	feed_url TEXT    NOT NULL,
+	item_id  TEXT    NOT NULL,
+	seq      INTEGER NOT NULL,
+	PRIMARY KEY (user_id, feed_url, item_id),
+	FOREIGN KEY (user_id, feed_url) REFERENCES subscriptions (user_id, feed_url)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS seen_items_seq ON seen_items (user_id, feed_url, seq);
+
+CREATE TABLE IF NOT EXISTS feeds (
+	feed_url      TEXT    PRIMARY KEY,
+	etag          TEXT    NOT NULL DEFAULT '',
+	last_modified TEXT    NOT NULL DEFAULT '',
+	ttl           INTEGER NOT NULL DEFAULT 0,
+	skip_hours    TEXT    NOT NULL DEFAULT '[]',
+	skip_days     TEXT    NOT NULL DEFAULT '[]',
+	max_age       INTEGER NOT NULL DEFAULT 0,
+	interval      INTEGER NOT NULL DEFAULT 0,
+	next_check    TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS feed_errors (
+	feed_url       TEXT    PRIMARY KEY,
+	error_count    INTEGER NOT NULL DEFAULT 0,
+	last_error     TEXT    NOT NULL DEFAULT '',
+	last_error_at  TEXT    NOT NULL DEFAULT '',
+	first_error_at TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS chats (
+	chat_id  INTEGER PRIMARY KEY,
+	timezone TEXT    NOT NULL DEFAULT '',
+	template TEXT    NOT NULL DEFAULT ''
+);
+`,
+	// 1 to 2: subscriptions belong to chats rather than users, recording
+	// who created them. Of several members' subscriptions to the same feed
+	// in one chat, the lowest user ID's is kept and the others are merged
+	// into it as migrateChatSubscriptions does for JSON: it takes on their
+	// seen items, ahead of its own and renumbered since each subscription
+	// counted seq separately, their template if it has none, their broken
+	// flag and their latest notice times, compared with julianday since
+	// they may have been written with different UTC offsets.
+	`
+CREATE TABLE chat_subscriptions (
+	chat_id         INTEGER NOT NULL,
+	feed_url        TEXT    NOT NULL,
+	created_by      INTEGER NOT NULL DEFAULT 0,
+	title           TEXT    NOT NULL DEFAULT '',
+	description     TEXT    NOT NULL DEFAULT '',
+	link            TEXT    NOT NULL DEFAULT '',
+	last_checked    TEXT    NOT NULL DEFAULT '',
+	broken          INTEGER NOT NULL DEFAULT 0,
+	error_notice_at TEXT    NOT NULL DEFAULT '',
+	snoozed_until   TEXT    NOT NULL DEFAULT '',
+	template        TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (chat_id, feed_url)
+);
+INSERT OR IGNORE INTO chat_subscriptions
+	SELECT chat_id, feed_url, user_id, title, description, link,
+		last_checked, broken, error_notice_at, snoozed_until, template
+	FROM subscriptions ORDER BY user_id;
+UPDATE chat_subscriptions SET
+	template = CASE WHEN template != '' THEN template ELSE COALESCE((
+		SELECT s.template FROM subscriptions s
+		WHERE s.chat_id = chat_subscriptions.chat_id AND s.feed_url = chat_subscriptions.feed_url
+			AND s.template != ''
+		ORDER BY s.user_id LIMIT 1), '') END,
+	broken = (
+		SELECT MAX(s.broken) FROM subscriptions s
+		WHERE s.chat_id = chat_subscriptions.chat_id AND s.feed_url = chat_subscriptions.feed_url),
+	error_notice_at = (
+		SELECT s.error_notice_at FROM subscriptions s
+		WHERE s.chat_id = chat_subscriptions.chat_id AND s.feed_url = chat_subscriptions.feed_url
+		ORDER BY julianday(s.error_notice_at) DESC LIMIT 1),
+	snoozed_until = (
+		SELECT s.snoozed_until FROM subscriptions s
+		WHERE s.chat_id = chat_subscriptions.chat_id AND s.feed_url = chat_subscriptions.feed_url
+		ORDER BY julianday(s.snoozed_until) DESC LIMIT 1);
+
+CREATE TABLE chat_seen_items (
+	chat_id  INTEGER NOT NULL,
+	feed_url TEXT    NOT NULL,
+	item_id  TEXT    NOT NULL,
+	seq      INTEGER NOT NULL,
+	PRIMARY KEY (chat_id, feed_url, item_id),
+	FOREIGN KEY (chat_id, feed_url) REFERENCES chat_subscriptions (chat_id, feed_url)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+INSERT INTO chat_seen_items
+	SELECT chat_id, feed_url, item_id, ROW_NUMBER() OVER (PARTITION BY chat_id, feed_url
+		ORDER BY MAX(user_id = created_by), COALESCE(MAX(CASE WHEN user_id = created_by THEN seq END), MAX(seq)), item_id)
+	FROM chat_subscriptions JOIN subscriptions USING (chat_id, feed_url) JOIN seen_items USING (user_id, feed_url)
+	GROUP BY chat_id, feed_url, item_id;
+
+DROP TABLE seen_items;
+DROP TABLE subscriptions;
+ALTER TABLE chat_subscriptions RENAME TO subscriptions;
+ALTER TABLE chat_seen_items RENAME TO seen_items;
+CREATE INDEX subscriptions_feed_url ON subscriptions (feed_url);
+CREATE INDEX seen_items_seq ON seen_items (chat_id, feed_url, seq);
+`,
+	// 2 to 3: subscriptions record their first successful check.
+	// Existing ones with seen items have had it.
+	`
+ALTER TABLE subscriptions ADD COLUMN initialized INTEGER NOT NULL DEFAULT 0;
+UPDATE subscriptions SET initialized = 1
+	WHERE EXISTS (SELECT 1 FROM seen_items WHERE seen_items.chat_id = subscriptions.chat_id
+		AND seen_items.feed_url = subscriptions.feed_url);
+`,
+	// 3 to 4: feeds remember a declared URL that failed to serve them.
+	`
+ALTER TABLE feeds ADD COLUMN failed_probe TEXT NOT NULL DEFAULT '';
+`,
+	// 4 to 5: subscriptions record that their chat can't be delivered to.
+	`
+ALTER TABLE subscriptions ADD COLUMN unreachable INTEGER NOT NULL DEFAULT 0;
+`,
+	// 5 to 6: subscriptions count the checks that left items unsent.
+	`
+ALTER TABLE subscriptions ADD COLUMN undelivered_checks INTEGER NOT NULL DEFAULT 0;
+`,
+}
+
+// SQLiteStore is a Store kept in an SQLite database, which unlike the JSON
+// Database only writes what each call changes.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	pragmas := url.Values{"_pragma": {"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"}}
+	db, err := sql.Open("sqlite", "file:"+path+"?"+pragmas.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite serializes writers anyway; a single connection avoids
+	// "database is locked" errors between the bot's own goroutines.
+	db.SetMaxOpenConns(1)
+
+	store := &SQLiteStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrate brings the schema up to date, refusing databases written by a
+// newer version of the bot.
+func (s *SQLiteStore) migrate() error {
+	return s.inTx(func(tx *sql.Tx) error {
+		var version int
+		if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			return fmt.Errorf("failed to read schema version: %w", err)
+		}
+		if version > len(sqliteMigrations) {
+			return fmt.Errorf("%w (schema version %d, this version supports up to %d)",
+				errNewerSchema, version, len(sqliteMigrations))
+		}
+		for ; version < len(sqliteMigrations); version++ {
+			if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+				return fmt.Errorf("failed to migrate database from version %d: %w", version, err)
+			}
+			if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+				return fmt.Errorf("failed to set schema version: %w", err)
+			}
+			if version > 0 {
+				log.Printf("Migrated SQLite database from version %d to %d", version, version+1)
+			}
+		}
+		return nil
+	})
+}
+
+// Flush implements Store. Every call is committed before it returns.
+func (s *SQLiteStore) Flush() error {
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// sqlQueryer is implemented by *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds.
+func (s *SQLiteStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const subscriptionColumns = `chat_id, feed_url, created_by, title, description, link,
+	last_checked, broken, error_notice_at, snoozed_until, template, initialized, unreachable,
+	undelivered_checks`
+
+// querySubscriptions returns the subscriptions matching where, a condition
+// on the subscriptions table, with their seen items.
+func querySubscriptions(q sqlQueryer, where string, args ...any) ([]*Subscription, error) {
+	rows, err := q.Query("SELECT "+subscriptionColumns+" FROM subscriptions WHERE "+where+" ORDER BY rowid", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	type subKey struct {
+		chatID  int64
+		feedURL string
+	}
+	subs := []*Subscription{}
+	byKey := make(map[subKey]*Subscription)
+	for rows.Next() {
+		sub := &Subscription{}
+		err := rows.Scan(&sub.ChatID, &sub.FeedURL, &sub.CreatedBy,
+			&sub.FeedInfo.Title, &sub.FeedInfo.Description, &sub.FeedInfo.Link,
+			&sub.LastChecked, &sub.Broken, &sub.ErrorNoticeAt, &sub.SnoozedUntil, &sub.Template, &sub.Initialized,
+			&sub.Unreachable, &sub.UndeliveredChecks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read subscription: %w", err)
+		}
+		subs = append(subs, sub)
+		byKey[subKey{sub.ChatID, sub.FeedURL}] = sub
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	if len(subs) == 0 {
+		return subs, nil
+	}
+
+	seen, err := q.Query("SELECT chat_id, feed_url, item_id FROM subscriptions JOIN seen_items USING (chat_id, feed_url) "+
+		"WHERE "+where+" ORDER BY seq", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query seen items: %w", err)
+	}
+	defer seen.Close()
+	for seen.Next() {
+		var key subKey
+		var id string
+		if err := seen.Scan(&key.chatID, &key.feedURL, &id); err != nil {
+			return nil, fmt.Errorf("failed to read seen item: %w", err)
+		}
+		if sub, ok := byKey[key]; ok {
+			sub.SeenItems = append(sub.SeenItems, id)
+		}
+	}
+	return subs, seen.Err()
+}
+
+func (s *SQLiteStore) AddSubscription(sub *Subscription) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM subscriptions WHERE chat_id = ? AND feed_url = ?)",
+			sub.ChatID, sub.FeedURL).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to look up subscription: %w", err)
+		}
+		if exists {
+			return fmt.Errorf("already subscribed to this feed")
+		}
+
+		sub.LastChecked = time.Now().Format(time.RFC3339)
+		_, err = tx.Exec("INSERT INTO subscriptions ("+subscriptionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			sub.ChatID, sub.FeedURL, sub.CreatedBy,
+			sub.FeedInfo.Title, sub.FeedInfo.Description, sub.FeedInfo.Link,
+			sub.LastChecked, sub.Broken, sub.ErrorNoticeAt, sub.SnoozedUntil, sub.Template, sub.Initialized, sub.Unreachable,
+			sub.UndeliveredChecks)
+		if err != nil {
+			return fmt.Errorf("failed to add subscription: %w", err)
+		}
+		return insertSeenItems(tx, sub.ChatID, sub.FeedURL, sub.SeenItems, max(maxSeenItems, len(sub.SeenItems)))
+	})
+}
+
+func (s *SQLiteStore) RemoveSubscription(chatID int64, feedURL string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM subscriptions WHERE chat_id = ? AND feed_url = ?", chatID, feedURL); err != nil {
+			return fmt.Errorf("failed to remove subscription: %w", err)
+		}
+		_, err := tx.Exec("DELETE FROM feeds WHERE feed_url = ? AND NOT EXISTS (SELECT 1 FROM subscriptions WHERE feed_url = ?)",
+			feedURL, feedURL)
+		return err
+	})
+}
+
+func (s *SQLiteStore) GetChatSubscriptions(chatID int64) ([]*Subscription, error) {
+	return querySubscriptions(s.db, "chat_id = ?", chatID)
+}
+
+func (s *SQLiteStore) GetAllSubscriptions() ([]*Subscription, error) {
+	return querySubscriptions(s.db, "1 = 1")
+}
+
+// UpdateLastChecked records a completed check of feedURL and marks seenIDs
+// as seen for the subscription.
+func (s *SQLiteStore) UpdateLastChecked(chatID int64, feedURL string, seenIDs []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec("UPDATE subscriptions SET last_checked = ?, initialized = 1 WHERE chat_id = ? AND feed_url = ?",
+			time.Now().Format(time.RFC3339), chatID, feedURL)
+		if err != nil {
+			return fmt.Errorf("failed to update subscription: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("subscription not found")
+		}
+		return insertSeenItems(tx, chatID, feedURL, seenIDs, max(maxSeenItems, len(seenIDs)))
+	})
+}
+
+// insertSeenItems is addSeenItems for the seen_items table: it moves ids
+// to the most recent end of the subscription's seen items, then evicts the
+// least recently seen until at most limit remain.
+func insertSeenItems(tx *sql.Tx, chatID int64, feedURL string, ids []string, limit int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var seq int64
+	err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM seen_items WHERE chat_id = ? AND feed_url = ?",
+		chatID, feedURL).Scan(&seq)
+	if err != nil {
+		return fmt.Errorf("failed to read seen items: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO seen_items (chat_id, feed_url, item_id, seq) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_id, feed_url, item_id) DO UPDATE SET seq = excluded.seq`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare seen items: %w", err)
+	}
+	defer stmt.Close()
+
+	added := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if added[id] {
+			continue
+		}
+		added[id] = true
+		seq++
+		if _, err := stmt.Exec(chatID, feedURL, id, seq); err != nil {
+			return fmt.Errorf("failed to record seen item: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM seen_items WHERE chat_id = ? AND feed_url = ? AND seq NOT IN (
+		SELECT seq FROM seen_items WHERE chat_id = ? AND feed_url = ? ORDER BY seq DESC LIMIT ?)`,
+		chatID, feedURL, chatID, feedURL, limit)
+	if err != nil {
+		return fmt.Errorf("failed to evict seen items: %w", err)
+	}
+	return nil
+}
+
+// SetFeedBroken sets the Broken flag on every subscription to feedURL and
+// returns copies of the subscriptions whose flag changed.
+func (s *SQLiteStore) SetFeedBroken(feedURL string, broken bool) ([]*Subscription, error) {
+	var changed []*Subscription
+	err := s.inTx(func(tx *sql.Tx) error {
+		subs, err := querySubscriptions(tx, "feed_url = ? AND broken != ?", feedURL, broken)
+		if err != nil || len(subs) == 0 {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE subscriptions SET broken = ? WHERE feed_url = ?", broken, feedURL); err != nil {
+			return fmt.Errorf("failed to update subscriptions: %w", err)
+		}
+		for _, sub := range subs {
+			sub.Broken = broken
+		}
+		changed = subs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// SetChatUnreachable sets the Unreachable flag on every subscription of
+// chatID and returns copies of the subscriptions whose flag changed.
+func (s *SQLiteStore) SetChatUnreachable(chatID int64, unreachable bool) ([]*Subscription, error) {
+	var changed []*Subscription
+	err := s.inTx(func(tx *sql.Tx) error {
+		subs, err := querySubscriptions(tx, "chat_id = ? AND unreachable != ?", chatID, unreachable)
+		if err != nil || len(subs) == 0 {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE subscriptions SET unreachable = ? WHERE chat_id = ?", unreachable, chatID); err != nil {
+			return fmt.Errorf("failed to update subscriptions: %w", err)
+		}
+		for _, sub := range subs {
+			sub.Unreachable = unreachable
+		}
+		changed = subs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// updateSubscription sets column to value on a subscription.
+func (s *SQLiteStore) updateSubscription(chatID int64, feedURL, column string, value any) error {
+	res, err := s.db.Exec("UPDATE subscriptions SET "+column+" = ? WHERE chat_id = ? AND feed_url = ?",
+		value, chatID, feedURL)
+	if err != nil {
+		return fmt.Errorf("failed to update subscription: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("subscription not found")
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetUndeliveredChecks(chatID int64, feedURL string, checks int) error {
+	return s.updateSubscription(chatID, feedURL, "undelivered_checks", checks)
+}
+
+func (s *SQLiteStore) RecordErrorNotice(chatID int64, feedURL string) error {
+	return s.updateSubscription(chatID, feedURL, "error_notice_at", time.Now().Format(time.RFC3339))
+}
+
+func (s *SQLiteStore) SnoozeErrorNotices(chatID int64, feedURL string, until time.Time) error {
+	return s.updateSubscription(chatID, feedURL, "snoozed_until", until.Format(time.RFC3339))
+}
+
+func (s *SQLiteStore) SetSubscriptionTemplate(chatID int64, feedURL, template string) error {
+	return s.updateSubscription(chatID, feedURL, "template", template)
+}
+
+// MoveFeed re-keys every subscription, error and state of oldURL under
+// newURL and returns copies of the moved subscriptions. A subscriber that
+// already follows newURL just loses the old subscription.
+func (s *SQLiteStore) MoveFeed(oldURL, newURL string) ([]*Subscription, error) {
+	var moved []*Subscription
+	err := s.inTx(func(tx *sql.Tx) error {
+		const followsNew = "chat_id IN (SELECT chat_id FROM subscriptions WHERE feed_url = ?)"
+		subs, err := querySubscriptions(tx, "feed_url = ? AND NOT "+followsNew, oldURL, newURL)
+		if err != nil {
+			return err
+		}
+
+		steps := []struct {
+			query string
+			args  []any
+		}{
+			{"DELETE FROM subscriptions WHERE feed_url = ? AND " + followsNew, []any{oldURL, newURL}},
+			// Seen items follow through ON UPDATE CASCADE.
+			{"UPDATE subscriptions SET feed_url = ? WHERE feed_url = ?", []any{newURL, oldURL}},
+			{"DELETE FROM feed_errors WHERE feed_url = ? AND EXISTS (SELECT 1 FROM feed_errors WHERE feed_url = ?)", []any{newURL, oldURL}},
+			{"UPDATE feed_errors SET feed_url = ? WHERE feed_url = ?", []any{newURL, oldURL}},
+			{"UPDATE OR IGNORE feeds SET feed_url = ? WHERE feed_url = ?", []any{newURL, oldURL}},
+			{"DELETE FROM feeds WHERE feed_url = ?", []any{oldURL}},
+		}
+		for _, step := range steps {
+			if _, err := tx.Exec(step.query, step.args...); err != nil {
+				return fmt.Errorf("failed to move feed: %w", err)
+			}
+		}
+
+		for _, sub := range subs {
+			sub.FeedURL = newURL
+			moved = append(moved, sub)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return moved, nil
+}
+
+func (s *SQLiteStore) RecordFeedError(feedURL string, err error) error {
+	now := time.Now().Format(time.RFC3339)
+	_, dbErr := s.db.Exec(`INSERT INTO feed_errors (feed_url, error_count, last_error, last_error_at, first_error_at)
+		VALUES (?, 1, ?, ?, ?)
+		ON CONFLICT (feed_url) DO UPDATE SET error_count = error_count + 1,
+			last_error = excluded.last_error, last_error_at = excluded.last_error_at`,
+		feedURL, err.Error(), now, now)
+	if dbErr != nil {
+		return fmt.Errorf("failed to record feed error: %w", dbErr)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ClearFeedError(feedURL string) error {
+	if _, err := s.db.Exec("DELETE FROM feed_errors WHERE feed_url = ?", feedURL); err != nil {
+		return fmt.Errorf("failed to clear feed error: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetFeedError(feedURL string) (*FeedError, bool) {
+	feedErr := &FeedError{FeedURL: feedURL}
+	err := s.db.QueryRow("SELECT error_count, last_error, last_error_at, first_error_at FROM feed_errors WHERE feed_url = ?", feedURL).
+		Scan(&feedErr.ErrorCount, &feedErr.LastError, &feedErr.LastErrorAt, &feedErr.FirstErrorAt)
+	if !found(err, "feed error", feedURL) {
+		return nil, false
+	}
+	return feedErr, true
+}
+
+func (s *SQLiteStore) GetFeedState(feedURL string) (*FeedState, bool) {
+	state := &FeedState{FeedURL: feedURL}
+	var skipHours, skipDays string
+	err := s.db.QueryRow(`SELECT etag, last_modified, ttl, skip_hours, skip_days, max_age, interval, next_check, failed_probe
+		FROM feeds WHERE feed_url = ?`, feedURL).
+		Scan(&state.ETag, &state.LastModified, &state.TTL, &skipHours, &skipDays, &state.MaxAge, &state.Interval, &state.NextCheck,
+			&state.FailedProbe)
+	if !found(err, "feed state", feedURL) {
+		return nil, false
+	}
+	if err := json.Unmarshal([]byte(skipHours), &state.SkipHours); err != nil {
+		log.Printf("Invalid skip hours for %s: %v", feedURL, err)
+	}
+	if err := json.Unmarshal([]byte(skipDays), &state.SkipDays); err != nil {
+		log.Printf("Invalid skip days for %s: %v", feedURL, err)
+	}
+	return state, true
+}
+
+func (s *SQLiteStore) UpdateFeedState(state *FeedState) error {
+	skipHours, _ := json.Marshal(state.SkipHours)
+	skipDays, _ := json.Marshal(state.SkipDays)
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO feeds
+		(feed_url, etag, last_modified, ttl, skip_hours, skip_days, max_age, interval, next_check, failed_probe)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		state.FeedURL, state.ETag, state.LastModified, state.TTL, string(skipHours), string(skipDays),
+		state.MaxAge, state.Interval, state.NextCheck, state.FailedProbe)
+	if err != nil {
+		return fmt.Errorf("failed to update feed state: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetChatSettings(chatID int64) (*ChatSettings, bool) {
+	settings := &ChatSettings{ChatID: chatID}
+	err := s.db.QueryRow("SELECT timezone, template FROM chats WHERE chat_id = ?", chatID).
+		Scan(&settings.Timezone, &settings.Template)
+	if !found(err, "chat settings", chatID) {
+		return nil, false
+	}
+	return settings, true
+}
+
+func (s *SQLiteStore) SetChatTimezone(chatID int64, timezone string) error {
+	return s.updateChat(chatID, "timezone", timezone)
+}
+
+func (s *SQLiteStore) SetChatTemplate(chatID int64, template string) error {
+	return s.updateChat(chatID, "template", template)
+}
+
+// updateChat sets column to value in a chat's settings, creating them if
+// needed.
+func (s *SQLiteStore) updateChat(chatID int64, column, value string) error {
+	_, err := s.db.Exec("INSERT INTO chats (chat_id, "+column+") VALUES (?, ?) "+
+		"ON CONFLICT (chat_id) DO UPDATE SET "+column+" = excluded."+column, chatID, value)
+	if err != nil {
+		return fmt.Errorf("failed to update chat settings: %w", err)
+	}
+	return nil
+}
+
+// found reports whether a single-row query found its row, logging errors
+// other than sql.ErrNoRows.
+func found(err error, what string, key any) bool {
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to read %s for %v: %v", what, key, err)
+	}
+	return false
+}
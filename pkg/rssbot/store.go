@@ -0,0 +1,69 @@
+package rssbot
+
+import (
+	"strings"
+	"time"
+)
+
+// Store is the bot's persistent state: subscriptions, per-feed state and
+// errors, and chat settings. Getters return copies that callers may keep
+// and modify.
+//
+// Database, a single JSON file, and SQLiteStore implement it; OpenStore
+// picks one from a -db value.
+type Store interface {
+	AddSubscription(sub *Subscription) error
+	RemoveSubscription(chatID int64, feedURL string) error
+	GetChatSubscriptions(chatID int64) ([]*Subscription, error)
+	GetAllSubscriptions() ([]*Subscription, error)
+	UpdateLastChecked(chatID int64, feedURL string, seenIDs []string) error
+	SetUndeliveredChecks(chatID int64, feedURL string, checks int) error
+	SetFeedBroken(feedURL string, broken bool) ([]*Subscription, error)
+	SetChatUnreachable(chatID int64, unreachable bool) ([]*Subscription, error)
+	RecordErrorNotice(chatID int64, feedURL string) error
+	SnoozeErrorNotices(chatID int64, feedURL string, until time.Time) error
+	SetSubscriptionTemplate(chatID int64, feedURL, template string) error
+	MoveFeed(oldURL, newURL string) ([]*Subscription, error)
+
+	RecordFeedError(feedURL string, err error) error
+	ClearFeedError(feedURL string) error
+	GetFeedError(feedURL string) (*FeedError, bool)
+	GetFeedState(feedURL string) (*FeedState, bool)
+	UpdateFeedState(state *FeedState) error
+
+	GetChatSettings(chatID int64) (*ChatSettings, bool)
+	SetChatTimezone(chatID int64, timezone string) error
+	SetChatTemplate(chatID int64, template string) error
+
+	// Flush writes out any changes the store has buffered, and Close
+	// flushes it before releasing it.
+	Flush() error
+	Close() error
+}
+
+var (
+	_ Store = (*Database)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
+
+// OpenStore opens the store named by location: "sqlite:" followed by the
+// path of an SQLite database, or the path of a JSON database, optionally
+// prefixed with "json:". Either scheme may also be written with "//", as
+// in "sqlite:///var/lib/rssbot/db.sqlite".
+func OpenStore(location string) (Store, error) {
+	if path, ok := cutScheme(location, "sqlite"); ok {
+		return NewSQLiteStore(path)
+	}
+	if path, ok := cutScheme(location, "json"); ok {
+		location = path
+	}
+	return NewDatabase(location)
+}
+
+func cutScheme(location, scheme string) (string, bool) {
+	rest, ok := strings.CutPrefix(location, scheme+":")
+	if !ok {
+		return location, false
+	}
+	return strings.TrimPrefix(rest, "//"), true
+}
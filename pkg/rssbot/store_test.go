@@ -0,0 +1,368 @@
+package rssbot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// storeBackends open a new, empty store at a path in dir, or reopen the one
+// already there.
+var storeBackends = map[string]func(dir string) (Store, error){
+	"json": func(dir string) (Store, error) {
+		return OpenStore(filepath.Join(dir, "db.json"))
+	},
+	"sqlite": func(dir string) (Store, error) {
+		return OpenStore("sqlite://" + filepath.Join(dir, "db.sqlite"))
+	},
+}
+
+// forEachStore runs test against a fresh store of each backend.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store, reopen func() Store)) {
+	for name, open := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+
+			// reopen flushes the store and opens it again, as if the
+			// bot had been restarted.
+			reopen := func() Store {
+				t.Helper()
+				if err := store.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				reopened, err := open(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { reopened.Close() })
+				return reopened
+			}
+			test(t, store, reopen)
+		})
+	}
+}
+
+func TestStoreSubscriptions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		sub := &Subscription{
+			ChatID:    10,
+			FeedURL:   "https://example.com/feed",
+			CreatedBy: 1,
+			FeedInfo:  FeedInfo{Title: "Example", Description: "Desc", Link: "https://example.com"},
+		}
+		if err := store.AddSubscription(sub); err != nil {
+			t.Fatal(err)
+		}
+		if sub.LastChecked == "" {
+			t.Error("Expected AddSubscription to set LastChecked")
+		}
+		if err := store.AddSubscription(&Subscription{ChatID: 10, FeedURL: sub.FeedURL, CreatedBy: 2}); err == nil {
+			t.Error("Expected error for a duplicate subscription")
+		}
+		if err := store.AddSubscription(&Subscription{ChatID: 20, FeedURL: sub.FeedURL, CreatedBy: 2}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.UpdateLastChecked(10, sub.FeedURL, []string{"a", "b", "c"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateLastChecked(10, sub.FeedURL, []string{"a", "d"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateLastChecked(10, "https://example.com/missing", nil); err == nil {
+			t.Error("Expected error for a missing subscription")
+		}
+		if err := store.SetUndeliveredChecks(10, sub.FeedURL, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetSubscriptionTemplate(10, sub.FeedURL, "{{.Title}}"); err != nil {
+			t.Fatal(err)
+		}
+		until := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+		if err := store.SnoozeErrorNotices(10, sub.FeedURL, until); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RecordErrorNotice(10, sub.FeedURL); err != nil {
+			t.Fatal(err)
+		}
+
+		subs, err := reopen().GetChatSubscriptions(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(subs) != 1 {
+			t.Fatalf("Expected 1 subscription, got %d", len(subs))
+		}
+		got := subs[0]
+		if got.CreatedBy != 1 || got.FeedInfo != sub.FeedInfo || got.Template != "{{.Title}}" {
+			t.Errorf("Unexpected subscription %+v", got)
+		}
+		if want := []string{"b", "c", "a", "d"}; !slices.Equal(got.SeenItems, want) {
+			t.Errorf("SeenItems = %v, want %v", got.SeenItems, want)
+		}
+		if !got.Initialized {
+			t.Error("Expected UpdateLastChecked to mark the subscription initialized")
+		}
+		if got.UndeliveredChecks != 2 {
+			t.Errorf("UndeliveredChecks = %d, want 2", got.UndeliveredChecks)
+		}
+		if got.SnoozedUntil != until.Format(time.RFC3339) || got.ErrorNoticeAt == "" {
+			t.Errorf("Notice fields not persisted: %+v", got)
+		}
+
+		if subs, _ := store.GetChatSubscriptions(99); subs == nil || len(subs) != 0 {
+			t.Errorf("Expected an empty list for an unknown chat, got %v", subs)
+		}
+		if all, _ := store.GetAllSubscriptions(); len(all) != 2 {
+			t.Errorf("Expected 2 subscriptions in total, got %d", len(all))
+		}
+
+		if err := store.UpdateFeedState(&FeedState{FeedURL: sub.FeedURL, ETag: `"v1"`}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RemoveSubscription(10, sub.FeedURL); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := store.GetFeedState(sub.FeedURL); !ok {
+			t.Error("Expected feed state to remain while the feed has subscribers")
+		}
+		if err := store.RemoveSubscription(20, sub.FeedURL); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := store.GetFeedState(sub.FeedURL); ok {
+			t.Error("Expected feed state to be dropped with the last subscriber")
+		}
+		if all, _ := store.GetAllSubscriptions(); len(all) != 0 {
+			t.Errorf("Expected no subscriptions, got %d", len(all))
+		}
+	})
+}
+
+func TestStoreSeenItemsLimit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		sub := &Subscription{ChatID: 1, FeedURL: "https://example.com/feed"}
+		if err := store.AddSubscription(sub); err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for i := range maxSeenItems + 10 {
+			ids = append(ids, time.Duration(i).String())
+		}
+		if err := store.UpdateLastChecked(1, sub.FeedURL, ids[:maxSeenItems]); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateLastChecked(1, sub.FeedURL, ids[maxSeenItems:]); err != nil {
+			t.Fatal(err)
+		}
+
+		subs, _ := store.GetChatSubscriptions(1)
+		if want := ids[10:]; !slices.Equal(subs[0].SeenItems, want) {
+			t.Errorf("Expected the %d most recent items, got %d starting with %v",
+				len(want), len(subs[0].SeenItems), subs[0].SeenItems[:1])
+		}
+	})
+}
+
+func TestStoreFeeds(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		const oldURL, newURL = "https://old.example.com/feed", "https://new.example.com/feed"
+		for _, sub := range []*Subscription{
+			{ChatID: 1, FeedURL: oldURL},
+			{ChatID: 2, FeedURL: oldURL},
+			{ChatID: 2, FeedURL: newURL},
+		} {
+			if err := store.AddSubscription(sub); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.UpdateLastChecked(1, oldURL, []string{"x"}); err != nil {
+			t.Fatal(err)
+		}
+
+		changed, err := store.SetFeedBroken(oldURL, true)
+		if err != nil || len(changed) != 2 || !changed[0].Broken {
+			t.Errorf("SetFeedBroken() = %v, %v; want 2 broken subscriptions", changed, err)
+		}
+		if changed, _ := store.SetFeedBroken(oldURL, true); len(changed) != 0 {
+			t.Errorf("Expected no changes on repeat, got %d", len(changed))
+		}
+
+		changed, err = store.SetChatUnreachable(2, true)
+		if err != nil || len(changed) != 2 || !changed[0].Unreachable {
+			t.Errorf("SetChatUnreachable() = %v, %v; want chat 2's 2 subscriptions", changed, err)
+		}
+		if changed, _ := store.SetChatUnreachable(2, true); len(changed) != 0 {
+			t.Errorf("Expected no changes on repeat, got %d", len(changed))
+		}
+
+		store.RecordFeedError(oldURL, errors.New("first"))
+		store.RecordFeedError(oldURL, errors.New("second"))
+		feedErr, ok := store.GetFeedError(oldURL)
+		if !ok || feedErr.ErrorCount != 2 || feedErr.LastError != "second" || feedErr.FirstErrorAt == "" {
+			t.Errorf("Unexpected feed error %+v", feedErr)
+		}
+		state := &FeedState{FeedURL: oldURL, ETag: `"e"`, SkipHours: []int{1, 2}, SkipDays: []string{"Sunday"}, TTL: 60, Interval: 3600, NextCheck: "2030-01-01T00:00:00Z", FailedProbe: "https://example.com/gone"}
+		if err := store.UpdateFeedState(state); err != nil {
+			t.Fatal(err)
+		}
+
+		moved, err := store.MoveFeed(oldURL, newURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(moved) != 1 || moved[0].ChatID != 1 || moved[0].FeedURL != newURL {
+			t.Errorf("Expected chat 1's subscription to move, got %+v", moved)
+		}
+
+		store = reopen()
+		subs, _ := store.GetChatSubscriptions(1)
+		if len(subs) != 1 || subs[0].FeedURL != newURL || !slices.Equal(subs[0].SeenItems, []string{"x"}) {
+			t.Errorf("Unexpected subscriptions for chat 1 after move: %+v", subs)
+		}
+		if subs, _ := store.GetChatSubscriptions(2); len(subs) != 1 || subs[0].FeedURL != newURL || !subs[0].Unreachable {
+			t.Errorf("Expected chat 2 to keep only the new subscription, got %+v", subs)
+		}
+		if _, ok := store.GetFeedError(oldURL); ok {
+			t.Error("Expected the old feed error to be gone")
+		}
+		if feedErr, ok := store.GetFeedError(newURL); !ok || feedErr.FeedURL != newURL || feedErr.ErrorCount != 2 {
+			t.Errorf("Expected the feed error to move, got %+v", feedErr)
+		}
+		got, ok := store.GetFeedState(newURL)
+		state.FeedURL = newURL
+		if !ok || got.ETag != state.ETag || !slices.Equal(got.SkipHours, state.SkipHours) ||
+			!slices.Equal(got.SkipDays, state.SkipDays) || got.TTL != 60 || got.Interval != 3600 || got.NextCheck != state.NextCheck ||
+			got.FailedProbe != state.FailedProbe {
+			t.Errorf("GetFeedState() = %+v, want %+v", got, state)
+		}
+
+		if err := store.ClearFeedError(newURL); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := store.GetFeedError(newURL); ok {
+			t.Error("Expected the feed error to be cleared")
+		}
+	})
+}
+
+func TestStoreChatSettings(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		if _, ok := store.GetChatSettings(5); ok {
+			t.Error("Expected no settings for a new chat")
+		}
+		if err := store.SetChatTimezone(5, "Asia/Tokyo"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetChatTemplate(5, "{{.Link}}"); err != nil {
+			t.Fatal(err)
+		}
+		settings, ok := reopen().GetChatSettings(5)
+		if !ok || *settings != (ChatSettings{ChatID: 5, Timezone: "Asia/Tokyo", Template: "{{.Link}}"}) {
+			t.Errorf("GetChatSettings() = %+v", settings)
+		}
+	})
+}
+
+func TestOpenStore(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{filepath.Join(dir, "a.json"), "*rssbot.Database"},
+		{"json:" + filepath.Join(dir, "b.json"), "*rssbot.Database"},
+		{"json://" + filepath.Join(dir, "c.json"), "*rssbot.Database"},
+		{"sqlite:" + filepath.Join(dir, "d.sqlite"), "*rssbot.SQLiteStore"},
+		{"sqlite://" + filepath.Join(dir, "e.sqlite"), "*rssbot.SQLiteStore"},
+	}
+	for _, tt := range tests {
+		store, err := OpenStore(tt.location)
+		if err != nil {
+			t.Fatalf("OpenStore(%q): %v", tt.location, err)
+		}
+		if got := fmt.Sprintf("%T", store); got != tt.want {
+			t.Errorf("OpenStore(%q) = %s, want %s", tt.location, got, tt.want)
+		}
+		store.Close()
+	}
+}
+
+func TestSQLiteStoreMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	const feedURL = "https://example.com/feed"
+
+	// A store from before subscriptions belonged to chats, in which two
+	// members of group -100 subscribed it to the same feed.
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup := sqliteMigrations[0] + `
+		INSERT INTO subscriptions (user_id, chat_id, feed_url, title, template, broken, error_notice_at, snoozed_until) VALUES
+			(2, -100, '` + feedURL + `', 'Example', '{{.Title}}', 1, '2024-05-01T12:00:00Z', '2030-01-01T00:00:00Z'),
+			(1, -100, '` + feedURL + `', 'Example', '', 0, '2024-05-01T09:00:00-05:00', '2030-01-01T02:00:00+03:00'),
+			(3, 3, '` + feedURL + `', 'Example', '', 0, '', '');
+		INSERT INTO seen_items (user_id, feed_url, item_id, seq) VALUES
+			(1, '` + feedURL + `', 'a', 1),
+			(1, '` + feedURL + `', 'c', 2),
+			(3, '` + feedURL + `', 'x', 1),
+			(2, '` + feedURL + `', 'a', 1),
+			(2, '` + feedURL + `', 'b', 2);`
+	if _, err := old.Exec(setup); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	group, _ := store.GetChatSubscriptions(-100)
+	// User 2's subscription is merged into user 1's, its own seen items
+	// ahead of user 1's.
+	if len(group) != 1 || group[0].CreatedBy != 1 || !group[0].Initialized || !slices.Equal(group[0].SeenItems, []string{"b", "a", "c"}) {
+		t.Fatalf("Expected one merged group subscription created by user 1, got %+v", group)
+	}
+	if sub := group[0]; sub.Template != "{{.Title}}" || !sub.Broken || sub.SnoozedUntil != "2030-01-01T00:00:00Z" {
+		t.Errorf("Expected user 2's template and error state to carry over, got %+v", sub)
+	}
+	// The notice times are the latest ones even where their text sorts
+	// the other way.
+	if sub := group[0]; sub.ErrorNoticeAt != "2024-05-01T09:00:00-05:00" {
+		t.Errorf("ErrorNoticeAt = %q, want user 1's later notice", sub.ErrorNoticeAt)
+	}
+	var seqs, distinct int
+	store.db.QueryRow("SELECT COUNT(seq), COUNT(DISTINCT seq) FROM seen_items WHERE chat_id = -100").Scan(&seqs, &distinct)
+	if seqs != 3 || distinct != 3 {
+		t.Errorf("Expected 3 distinct seq values, got %d of %d", distinct, seqs)
+	}
+	private, _ := store.GetChatSubscriptions(3)
+	if len(private) != 1 || private[0].CreatedBy != 3 || !slices.Equal(private[0].SeenItems, []string{"x"}) {
+		t.Errorf("Unexpected private subscription %+v", private)
+	}
+
+	if err := store.UpdateLastChecked(-100, feedURL, []string{"c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveSubscription(-100, feedURL); err != nil {
+		t.Fatal(err)
+	}
+	var version, seen int
+	store.db.QueryRow("PRAGMA user_version").Scan(&version)
+	store.db.QueryRow("SELECT COUNT(*) FROM seen_items").Scan(&seen)
+	if version != len(sqliteMigrations) || seen != 1 {
+		t.Errorf("user_version = %d and %d seen items left, want %d and 1", version, seen, len(sqliteMigrations))
+	}
+}
@@ -0,0 +1,245 @@
+package rssbot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// defaultTemplate is the message layout used unless a chat or subscription
+// sets its own with /template.
+const defaultTemplate = `<b><u>{{.Title}}</u></b>
+
+{{with .Excerpt}}{{.}}
+
+{{end}}via <a href="{{.Link}}">{{.Feed.Title}}</a>{{with .Author}} (author: {{.}}){{end}}{{with .Date}}
+{{.}}{{end}}`
+
+// maxTemplateLength bounds the source of a message template, in UTF-16 code
+// units like Telegram's own limits.
+const maxTemplateLength = 2048
+
+// templateExcerptLength is the length of .Excerpt in custom templates when
+// Config.ExcerptLength doesn't set one.
+const templateExcerptLength = 300
+
+var defaultMessageTemplate = template.Must(newMessageTemplate(defaultTemplate))
+
+// templateData is what message templates are executed with. Its strings
+// are HTML-escaped, except Excerpt, which is already Telegram-safe HTML.
+type templateData struct {
+	Title   string
+	Link    string
+	Author  string
+	Excerpt string
+	// Date is the publication date formatted by formatItemDate and
+	// Published the same time in the chat's time zone, for templates
+	// that want another format. Both are zero if the feed has no date.
+	Date      string
+	Published time.Time
+	Tags      []string
+	Feed      templateFeed
+}
+
+type templateFeed struct {
+	Title       string
+	Link        string
+	Description string
+	URL         string
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	// hashtag turns a tag into a Telegram hashtag: "Open Source" becomes
+	// "#Open_Source".
+	"hashtag": func(tag string) string {
+		tag = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return '_'
+		}, html.UnescapeString(tag))
+		return "#" + strings.Trim(tag, "_")
+	},
+	// truncate shortens escaped text to n characters, ending it with "…".
+	"truncate": func(n int, s string) string {
+		return truncateMessage(s, n, true)
+	},
+}
+
+func newMessageTemplate(text string) (*template.Template, error) {
+	return template.New("message").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// Sample items /template checks templates against: a complete one and one
+// with only the fields every item has.
+var (
+	sampleTemplateData = templateData{
+		Title:     "Sample post",
+		Link:      "https://example.com/posts/sample",
+		Author:    "Jane Doe",
+		Excerpt:   "The first lines of the <b>post</b>…",
+		Date:      formatItemDate(time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC), time.UTC),
+		Published: time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC),
+		Tags:      []string{"News", "Open Source"},
+		Feed: templateFeed{
+			Title:       "Example Blog",
+			Link:        "https://example.com/",
+			Description: "An example feed",
+			URL:         "https://example.com/feed.xml",
+		},
+	}
+	minimalTemplateData = templateData{
+		Title: "Sample post",
+		Link:  "https://example.com/posts/sample",
+		Feed:  templateFeed{Title: "Example Blog", URL: "https://example.com/feed.xml"},
+	}
+)
+
+// parseMessageTemplate parses a template set by a user and checks that it
+// renders sample items into valid messages that fit in one Telegram
+// message, so that mistakes are reported when the template is set rather
+// than when items arrive.
+func parseMessageTemplate(text string) (*template.Template, error) {
+	if utf16Len(text) > maxTemplateLength {
+		return nil, fmt.Errorf("template is longer than %d characters", maxTemplateLength)
+	}
+	tmpl, err := newMessageTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range []templateData{sampleTemplateData, minimalTemplateData} {
+		text, err := executeMessageTemplate(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		if atomsWidth(parseMessageAtoms(text, true)) > maxMessageLength {
+			return nil, fmt.Errorf("template produces messages longer than %d characters", maxMessageLength)
+		}
+	}
+	return tmpl, nil
+}
+
+// executeMessageTemplate renders data and checks the result is a message
+// Telegram will accept.
+func executeMessageTemplate(tmpl *template.Template, data templateData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(b.String())
+	if text == "" {
+		return "", errors.New("template produces an empty message")
+	}
+	if err := checkMessageHTML(text); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// messageHTMLTags are the tags Telegram's HTML parse mode accepts.
+var messageHTMLTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+	"s": true, "strike": true, "del": true, "span": true, "tg-spoiler": true,
+	"a": true, "tg-emoji": true, "code": true, "pre": true, "blockquote": true,
+}
+
+// checkMessageHTML reports unsupported or unbalanced tags in text.
+func checkMessageHTML(text string) error {
+	var open []string
+	for _, a := range parseMessageAtoms(text, true) {
+		if a.raw == "<" || a.raw == ">" {
+			return errors.New(`use &lt; and &gt; for "<" and ">" outside tags`)
+		}
+		if !strings.HasPrefix(a.raw, "<") {
+			continue
+		}
+		switch {
+		case !messageHTMLTags[a.tag]:
+			return fmt.Errorf("%s isn't a tag Telegram supports", a.raw)
+		case !a.end:
+			open = append(open, a.tag)
+		case len(open) == 0 || open[len(open)-1] != a.tag:
+			return fmt.Errorf("unexpected %s", a.raw)
+		default:
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("<%s> is never closed", open[len(open)-1])
+	}
+	return nil
+}
+
+// itemTemplate returns the template source for a subscription's items: its
+// own, else its chat's, else "".
+func (b *Bot) itemTemplate(sub *Subscription) string {
+	if sub.Template != "" {
+		return sub.Template
+	}
+	if settings, ok := b.db.GetChatSettings(sub.ChatID); ok {
+		return settings.Template
+	}
+	return ""
+}
+
+// renderItem formats an item for a subscription's chat with the template
+// from itemTemplate. If a custom template fails, which the checks in
+// parseMessageTemplate make unlikely, defaultTemplate is used instead. The
+// result is cut to one message, since a long title or a template looping
+// over many tags could otherwise spread an item over any number of them.
+func (b *Bot) renderItem(sub *Subscription, item FeedItem) string {
+	source := b.itemTemplate(sub)
+	data := b.newTemplateData(sub, item, source != "")
+	if source != "" {
+		tmpl, err := newMessageTemplate(source)
+		if err == nil {
+			var text string
+			if text, err = executeMessageTemplate(tmpl, data); err == nil {
+				return truncateMessage(text, maxMessageLength, true)
+			}
+		}
+		log.Printf("Template for %s in chat %d failed, using the default: %v", sub.FeedURL, sub.ChatID, err)
+	}
+
+	text, err := executeMessageTemplate(defaultMessageTemplate, data)
+	if err != nil {
+		log.Printf("Default template failed for %s: %v", sub.FeedURL, err)
+		text = fmt.Sprintf(`<a href="%s">%s</a>`, data.Link, data.Title)
+	}
+	return truncateMessage(text, maxMessageLength, true)
+}
+
+func (b *Bot) newTemplateData(sub *Subscription, item FeedItem, custom bool) templateData {
+	excerptLength := b.config.ExcerptLength
+	if excerptLength <= 0 && custom {
+		excerptLength = templateExcerptLength
+	}
+
+	data := templateData{
+		Title:   escapeHTML(strings.TrimSpace(html.UnescapeString(item.Title))),
+		Link:    escapeHTML(item.Link),
+		Author:  escapeHTML(strings.TrimSpace(item.Author)),
+		Excerpt: itemExcerpt(item, excerptLength),
+		Feed: templateFeed{
+			Title:       escapeHTML(html.UnescapeString(sub.FeedInfo.Title)),
+			Link:        escapeHTML(sub.FeedInfo.Link),
+			Description: escapeHTML(html.UnescapeString(sub.FeedInfo.Description)),
+			URL:         escapeHTML(sub.FeedURL),
+		},
+	}
+	for _, tag := range item.Tags {
+		data.Tags = append(data.Tags, escapeHTML(tag))
+	}
+	if !item.Published.IsZero() {
+		loc := b.chatLocation(sub.ChatID)
+		data.Date = formatItemDate(item.Published, loc)
+		data.Published = item.Published.In(loc)
+	}
+	return data
+}
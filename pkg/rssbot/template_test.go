@@ -0,0 +1,140 @@
+package rssbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultTemplate(t *testing.T) {
+	fake := &fakeTelegram{}
+	b := newTestBot(t, fake)
+	sub := &Subscription{ChatID: 1, FeedURL: "https://example.com/feed", FeedInfo: FeedInfo{Title: "Tom &amp; Jerry"}}
+
+	tests := []struct {
+		name          string
+		item          FeedItem
+		excerptLength int
+		want          string
+	}{
+		{
+			name: "Title and link",
+			item: FeedItem{Title: " <Hello> ", Link: "https://example.com/?a=1&b=2"},
+			want: "<b><u>&lt;Hello&gt;</u></b>\n\nvia <a href=\"https://example.com/?a=1&amp;b=2\">Tom &amp; Jerry</a>",
+		},
+		{
+			name: "Author and date",
+			item: FeedItem{
+				Title:     "Post",
+				Link:      "https://example.com/post",
+				Author:    "Jane",
+				Published: time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC),
+			},
+			want: "<b><u>Post</u></b>\n\nvia <a href=\"https://example.com/post\">Tom &amp; Jerry</a> (author: Jane)\nMar 1, 2024 09:30 UTC",
+		},
+		{
+			name:          "Excerpt",
+			item:          FeedItem{Title: "Post", Link: "https://example.com/post", Content: "Body", ContentType: contentText},
+			excerptLength: 100,
+			want:          "<b><u>Post</u></b>\n\nBody\n\nvia <a href=\"https://example.com/post\">Tom &amp; Jerry</a>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.config.ExcerptLength = tt.excerptLength
+			if got := b.renderItem(sub, tt.item); got != tt.want {
+				t.Errorf("renderItem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMessageTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{name: "Valid", text: `<b>{{.Title}}</b> {{.Link}}{{with .Tags}} {{join . ", "}}{{end}}`},
+		{name: "Functions", text: `{{truncate 20 .Title}} {{range .Tags}}{{hashtag .}} {{end}}`},
+		{name: "Published", text: `{{.Title}} {{if not .Published.IsZero}}{{.Published.Format "2006-01-02"}}{{end}}`},
+		{name: "Syntax error", text: `{{.Title`, wantErr: "unclosed action"},
+		{name: "Unknown field", text: `{{.Body}}`, wantErr: "can't evaluate field Body"},
+		{name: "Unknown function", text: `{{upper .Title}}`, wantErr: `function "upper" not defined`},
+		{name: "Fails without optional fields", text: `{{index .Tags 0}}`, wantErr: "index out of range"},
+		{name: "Empty output", text: `{{if false}}x{{end}}`, wantErr: "empty message"},
+		{name: "Unsupported tag", text: `<h1>{{.Title}}</h1>`, wantErr: "<h1> isn't a tag"},
+		{name: "Unclosed tag", text: `<b>{{.Title}}`, wantErr: "<b> is never closed"},
+		{name: "Misnested tags", text: `<b><i>{{.Title}}</b></i>`, wantErr: "unexpected </b>"},
+		{name: "Bare angle bracket", text: `{{.Title}} < {{.Link}}`, wantErr: "&lt;"},
+		{name: "Too long", text: strings.Repeat("x", maxTemplateLength+1), wantErr: "longer than"},
+		{name: "Output too long", text: strings.Repeat("{{.Link}}", 200), wantErr: "messages longer than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMessageTemplate(tt.text)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := parseMessageTemplate(defaultTemplate); err != nil {
+		t.Errorf("Default template is invalid: %v", err)
+	}
+}
+
+func TestRenderItemTemplates(t *testing.T) {
+	fake := &fakeTelegram{}
+	b := newTestBot(t, fake)
+
+	item := FeedItem{
+		Title:       "R&D",
+		Link:        "https://example.com/post",
+		Content:     "<p>Long <b>body</b></p>",
+		ContentType: contentHTML,
+		Tags:        []string{"Open Source", "C++"},
+		Published:   time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC),
+	}
+	sub := &Subscription{ChatID: 1, FeedURL: "https://example.com/feed", FeedInfo: FeedInfo{Title: "Blog"}}
+
+	if got := b.renderItem(sub, item); !strings.HasPrefix(got, "<b><u>R&amp;D</u></b>") {
+		t.Errorf("Expected the default template, got %q", got)
+	}
+
+	if err := b.db.SetChatTemplate(1, `{{.Feed.Title}}: {{.Title}} {{range .Tags}}{{hashtag .}} {{end}}`); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.renderItem(sub, item), "Blog: R&amp;D #Open_Source #C"; got != want {
+		t.Errorf("Chat template: got %q, want %q", got, want)
+	}
+
+	sub.Template = `{{.Excerpt}} <i>{{.Published.Format "2006-01-02"}}</i>`
+	if got, want := b.renderItem(sub, item), "Long <b>body</b> <i>2024-03-01</i>"; got != want {
+		t.Errorf("Subscription template: got %q, want %q", got, want)
+	}
+
+	sub.Template = `{{index .Tags 5}}`
+	if got := b.renderItem(sub, item); !strings.HasPrefix(got, "<b><u>R&amp;D</u></b>") {
+		t.Errorf("Expected a failing template to fall back to the default, got %q", got)
+	}
+
+	sub.Template = `<b>{{range .Tags}}{{$.Title}} {{end}}</b>`
+	item.Title = strings.Repeat("word ", 1000)
+	item.Tags = []string{"a", "b", "c"}
+	got := b.renderItem(sub, item)
+	if n := atomsWidth(parseMessageAtoms(got, true)); n > maxMessageLength {
+		t.Errorf("Rendered message is %d characters long, want at most %d", n, maxMessageLength)
+	}
+	if !strings.HasSuffix(got, "…</b>") {
+		t.Errorf("Expected a truncated message with its tags closed, got %q", got[len(got)-20:])
+	}
+}